@@ -213,7 +213,7 @@ local function setup_git_comparison(base_ref, implementations)
 
 	-- Build each implementation and check results
 	local build_commands = {
-		{ "Go", "go", "go build -o fixml fixml.go" },
+		{ "Go", "go", "go build -o fixml ." },
 		{ "Rust", "rust", "rustc -O -o fixml fixml.rs" },
 		{ "OCaml", "ocaml", "ocamlopt -I +unix -I +str unix.cmxa str.cmxa -o fixml fixml.ml" },
 		{ "Zig", "zig", "zig build -Doptimize=ReleaseFast && cp zig-out/bin/fixml fixml" },
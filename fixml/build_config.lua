@@ -6,7 +6,7 @@ local function build_all_optimized()
     
     -- Build Go (already optimized by default)
     print("  Building Go...")
-    local go_result = os.execute("cd go && go build -o fixml fixml.go 2>/dev/null")
+    local go_result = os.execute("cd go && go build -o fixml . 2>/dev/null")
     if go_result ~= 0 and go_result ~= true then
         print("    Warning: Go build failed")
     end
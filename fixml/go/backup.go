@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
+)
+
+// BACKUP_DIR is where replace runs with --backup keep their journal,
+// relative to the working directory unless --backup-dir names another
+const BACKUP_DIR = ".fixml-backups"
+const BACKUP_SUFFIX = ".bak"                     // Appended to backups kept next to the original
+const BACKUP_JOURNAL = "journal.jsonl"           // Files replaced by one run, one JSON object per line
+const BACKUP_STAMP = "20060102-150405.000000000" // Run directory names sort by start time
+
+// Values of --backup
+const (
+	BACKUP_MODE_BAK = "bak" // name.ext.bak next to each replaced file
+	BACKUP_MODE_DIR = "dir" // Copies in the run's directory below --backup-dir
+)
+
+// backupEntry is one line of a run journal. Size and ModTime identify the
+// backup as this run left it: a later run may put its own backup at the
+// same name.ext.bak.
+type backupEntry struct {
+	File    string `json:"file"`     // Absolute path of the replaced file
+	Backup  string `json:"backup"`   // Absolute path of its original content
+	Size    int64  `json:"size"`     // Size of the backup
+	ModTime int64  `json:"mod_time"` // Modification time of the backup, in nanoseconds since the Unix epoch
+}
+
+// stale returns an error when the backup is gone or no longer the one the
+// run kept
+func (e backupEntry) stale() error {
+	info, err := os.Stat(e.Backup)
+	if err != nil {
+		return fmt.Errorf("backup of '%s' is gone: %v", e.File, err)
+	}
+	if info.Size() != e.Size || info.ModTime().UnixNano() != e.ModTime {
+		return fmt.Errorf("backup '%s' was replaced by a later run", e.Backup)
+	}
+	return nil
+}
+
+// backupRun journals the files a replace run changed, so restore can roll
+// the run back. It is shared by the workers of one run.
+type backupRun struct {
+	mode     string
+	dir      string // This run's directory inside the backup directory
+	mu       sync.Mutex
+	journal  *os.File
+	copies   int // Backup names handed out in BACKUP_MODE_DIR
+	recorded int
+}
+
+func newBackupRun(root, mode string) (*backupRun, error) {
+	if err := os.MkdirAll(root, fixml.DIR_PERMISSIONS); err != nil {
+		return nil, fmt.Errorf("could not create backup directory: %v", err)
+	}
+	dir, err := os.MkdirTemp(root, time.Now().UTC().Format(BACKUP_STAMP)+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create backup directory: %v", err)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, fmt.Errorf("could not create backup directory: %v", err)
+	}
+	journal, err := os.OpenFile(filepath.Join(dir, BACKUP_JOURNAL), os.O_WRONLY|os.O_CREATE|os.O_APPEND, fixml.FILE_PERMISSIONS)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("could not create backup journal: %v", err)
+	}
+	return &backupRun{mode: mode, dir: dir, journal: journal}, nil
+}
+
+// path returns where the original of file is kept
+func (b *backupRun) path(file string) string {
+	abs, _ := filepath.Abs(file)
+	if b.mode == BACKUP_MODE_BAK {
+		return abs + BACKUP_SUFFIX
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.copies++
+	return filepath.Join(b.dir, fmt.Sprintf("%d-%s", b.copies, filepath.Base(file)))
+}
+
+// record adds a replaced file to the journal. Each entry is synced, so an
+// interrupted run can still be rolled back up to the last file it finished.
+func (b *backupRun) record(file, backup string) error {
+	info, err := os.Stat(backup)
+	if err != nil {
+		return fmt.Errorf("could not write backup journal: %v", err)
+	}
+	abs, _ := filepath.Abs(file)
+	line, _ := json.Marshal(backupEntry{File: abs, Backup: backup, Size: info.Size(), ModTime: info.ModTime().UnixNano()})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write backup journal: %v", err)
+	}
+	if err := b.journal.Sync(); err != nil {
+		return fmt.Errorf("could not write backup journal: %v", err)
+	}
+	b.recorded++
+	return nil
+}
+
+// finish closes the journal; a run that replaced nothing leaves no trace
+func (b *backupRun) finish() {
+	b.journal.Close()
+	if b.recorded == 0 {
+		os.RemoveAll(b.dir)
+	}
+}
+
+// restoreLastRun rolls back the most recent replace run journaled in root
+// and then discards its backups. A run whose backups have since been
+// replaced or removed is not restored at all. When a file cannot be
+// restored every backup is kept, so the restore can be repeated.
+func restoreLastRun(root string, out io.Writer) error {
+	runs, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read backup directory: %v", err)
+	}
+	dir := ""
+	for i := len(runs) - 1; i >= 0 && dir == ""; i-- {
+		if runs[i].IsDir() {
+			dir = filepath.Join(root, runs[i].Name())
+		}
+	}
+	if dir == "" {
+		return fmt.Errorf("no replace run to restore in '%s'", root)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, BACKUP_JOURNAL))
+	if err != nil {
+		return fmt.Errorf("could not read backup journal: %v", err)
+	}
+	var entries []backupEntry
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e backupEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return fmt.Errorf("could not read backup journal: line %d: %v", i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	for _, e := range entries {
+		if err := e.stale(); err != nil {
+			return fmt.Errorf("could not restore the run in '%s': %v; nothing was restored", dir, err)
+		}
+	}
+
+	failed := 0
+	for _, e := range entries {
+		if err := fixml.RestoreFile(e.Backup, e.File); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "Restored: %s\n", e.File)
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not restore %d of %d files; backups kept in '%s'", failed, len(entries), dir)
+	}
+
+	// Backups kept next to the files go with the run
+	for _, e := range entries {
+		if filepath.Dir(e.Backup) != dir {
+			os.Remove(e.Backup)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("could not remove backup directory: %v", err)
+	}
+	fmt.Fprintf(out, "Restored %d files from %s\n", len(entries), dir)
+	return nil
+}
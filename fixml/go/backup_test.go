@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
+)
+
+const UNFORMATTED = "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<a>\n<b>x</b>\n<b>x</b>\n</a>\n" // Changed by every replace run
+
+// replaceRun formats files in place as one --backup run journaled in root
+func replaceRun(t *testing.T, root, mode string, files ...string) {
+	t.Helper()
+	run, err := newBackupRun(root, mode)
+	if err != nil {
+		t.Fatalf("newBackupRun failed: %v", err)
+	}
+	defer run.finish()
+	for _, file := range files {
+		res, err := fixml.ProcessFile(file, fixml.Options{Replace: true, Backup: run.path(file)})
+		if err != nil {
+			t.Fatalf("ProcessFile(%s) failed: %v", file, err)
+		}
+		if err := run.record(file, res.BackupFile); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+}
+
+// writeInputs creates the named files with UNFORMATTED content in dir
+func writeInputs(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+	var files []string
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(UNFORMATTED), fixml.FILE_PERMISSIONS); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func assertContent(t *testing.T, file, want string) {
+	t.Helper()
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s =\n%s\nwant\n%s", file, got, want)
+	}
+}
+
+func TestRestoreLastRun(t *testing.T) {
+	for _, mode := range []string{BACKUP_MODE_BAK, BACKUP_MODE_DIR} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			root := filepath.Join(dir, BACKUP_DIR)
+			files := writeInputs(t, dir, "a.xml", "b.xml")
+
+			replaceRun(t, root, mode, files...)
+			formatted, _ := os.ReadFile(files[0])
+			if string(formatted) == UNFORMATTED {
+				t.Fatalf("the run left %s unchanged", files[0])
+			}
+
+			var out bytes.Buffer
+			if err := restoreLastRun(root, &out); err != nil {
+				t.Fatalf("restoreLastRun failed: %v", err)
+			}
+			for _, file := range files {
+				assertContent(t, file, UNFORMATTED)
+				if !strings.Contains(out.String(), "Restored: "+file+"\n") {
+					t.Errorf("output does not list %s:\n%s", file, out.String())
+				}
+				if _, err := os.Stat(file + BACKUP_SUFFIX); !os.IsNotExist(err) {
+					t.Errorf("%s%s was not removed: %v", file, BACKUP_SUFFIX, err)
+				}
+			}
+			if runs, _ := os.ReadDir(root); len(runs) != 0 {
+				t.Errorf("the run directory was not removed: %v", runs)
+			}
+
+			if err := restoreLastRun(root, &out); err == nil || !strings.Contains(err.Error(), "no replace run to restore") {
+				t.Errorf("second restoreLastRun error = %v, want nothing to restore", err)
+			}
+		})
+	}
+}
+
+func TestRestoreLastRunRestoresOnlyTheLatest(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, BACKUP_DIR)
+	files := writeInputs(t, dir, "a.xml")
+
+	// Run directories sort by their start time, so the later run is restored first
+	replaceRun(t, root, BACKUP_MODE_DIR, files...)
+	edited := UNFORMATTED + "<!-- edited -->\n"
+	os.WriteFile(files[0], []byte(edited), fixml.FILE_PERMISSIONS)
+	replaceRun(t, root, BACKUP_MODE_DIR, files...)
+
+	if err := restoreLastRun(root, &bytes.Buffer{}); err != nil {
+		t.Fatalf("restoreLastRun failed: %v", err)
+	}
+	assertContent(t, files[0], edited)
+	if err := restoreLastRun(root, &bytes.Buffer{}); err != nil {
+		t.Fatalf("restoreLastRun of the earlier run failed: %v", err)
+	}
+	assertContent(t, files[0], UNFORMATTED)
+}
+
+func TestRestoreLastRunRefusesStaleBackups(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoil func(t *testing.T, dir, file string) // Runs between the replace run and its restore
+		want  string
+	}{
+		{
+			"a later run replaced the backups",
+			func(t *testing.T, dir, file string) {
+				// Another --backup-dir, so the later run is not the last one here
+				os.WriteFile(file, []byte(UNFORMATTED+"<!-- edited -->\n"), fixml.FILE_PERMISSIONS)
+				replaceRun(t, filepath.Join(dir, "other"), BACKUP_MODE_BAK, file)
+			},
+			"was replaced by a later run",
+		},
+		{
+			"a backup was removed",
+			func(t *testing.T, dir, file string) {
+				os.Remove(file + BACKUP_SUFFIX)
+			},
+			"is gone",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			root := filepath.Join(dir, BACKUP_DIR)
+			files := writeInputs(t, dir, "a.xml", "b.xml")
+			replaceRun(t, root, BACKUP_MODE_BAK, files...)
+			tt.spoil(t, dir, files[1])
+			before, _ := os.ReadFile(files[0])
+
+			var out bytes.Buffer
+			err := restoreLastRun(root, &out)
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasSuffix(err.Error(), "nothing was restored") {
+				t.Fatalf("restoreLastRun error = %v, want %q and nothing restored", err, tt.want)
+			}
+			// Not even the files whose backups are intact
+			assertContent(t, files[0], string(before))
+			if out.Len() > 0 {
+				t.Errorf("restoreLastRun wrote %q", out.String())
+			}
+			if runs, _ := os.ReadDir(root); len(runs) != 1 {
+				t.Errorf("the run directory is gone: %v", runs)
+			}
+			if _, err := os.Stat(files[0] + BACKUP_SUFFIX); err != nil {
+				t.Errorf("the intact backup is gone: %v", err)
+			}
+		})
+	}
+}
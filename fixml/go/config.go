@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
+)
+
+// CONFIG_FILES are the configuration file names looked for in the directory
+// of each input and its parents, in order of preference
+var CONFIG_FILES = []string{".fixml.json", ".fixml.toml"}
+
+// ATTRIBUTE_INDENT_ALIGN_NAME is the --attribute-indent value that aligns
+// continued attributes with the first attribute of their tag
+const ATTRIBUTE_INDENT_ALIGN_NAME = "align"
+
+// DEDUP_MODES maps the values of the dedup setting to deduplication modes
+var DEDUP_MODES = map[string]fixml.DedupMode{
+	"siblings": fixml.DedupSiblings,
+	"global":   fixml.DedupGlobal,
+	"off":      fixml.DedupOff,
+}
+
+// NODE_POLICIES maps the values of the comments, cdata and
+// processing_instructions settings to deduplication policies
+var NODE_POLICIES = map[string]fixml.NodePolicy{
+	"keep":  fixml.NodeKeep,
+	"dedup": fixml.NodeDedup,
+}
+
+// PROFILES maps the values of the profile setting to document profiles
+var PROFILES = map[string]fixml.Profile{
+	"none":    fixml.ProfileNone,
+	"msbuild": fixml.ProfileMSBuild,
+}
+
+// VERSION_POLICIES maps the values of the version_policy setting to policies
+var VERSION_POLICIES = map[string]fixml.VersionPolicy{
+	"report":  fixml.VersionReport,
+	"highest": fixml.VersionHighest,
+	"lowest":  fixml.VersionLowest,
+	"first":   fixml.VersionFirst,
+}
+
+// config holds the settings that can come from a configuration file as well
+// as from the command line. Nil fields are not set, so a later source only
+// overrides what it actually specifies. It is only marshalled by
+// --print-config, after withDefaults, so no field is left out there.
+type config struct {
+	IndentWidth     *int             `json:"indent_width"`
+	IndentTabs      *bool            `json:"indent_tabs"`
+	AttributeIndent *attributeIndent `json:"attribute_indent"`
+	Dedup           *string          `json:"dedup"`                   // One of DEDUP_MODES
+	DedupExempt     []string         `json:"dedup_exempt"`            // Element names never removed
+	PreserveSpace   []string         `json:"preserve_space"`          // Element names whose whitespace is kept
+	Only            []string         `json:"only"`                    // Paths of the elements to format
+	Skip            []string         `json:"skip"`                    // Paths of the elements to leave as written
+	Comments        *string          `json:"comments"`                // One of NODE_POLICIES
+	CData           *string          `json:"cdata"`                   // One of NODE_POLICIES
+	ProcInsts       *string          `json:"processing_instructions"` // One of NODE_POLICIES
+	Warnings        map[string]bool  `json:"warnings"`                // Enabled state by warning ID
+	Profile         *string          `json:"profile"`                 // One of PROFILES
+	VersionPolicy   *string          `json:"version_policy"`          // One of VERSION_POLICIES
+	EOL             *string          `json:"eol"`
+	FinalNewline    *string          `json:"final_newline"`
+	Include         []string         `json:"include"` // Only honoured at the top level
+	Exclude         []string         `json:"exclude"`
+}
+
+// configFile is the content of a .fixml.json or .fixml.toml file.
+// Overrides apply, in order, to the files their globs match, like
+// .editorconfig sections.
+type configFile struct {
+	config
+	Overrides []configOverride `json:"overrides,omitempty"`
+
+	path string
+	dir  string // Globs of overrides are relative to this directory
+}
+
+type configOverride struct {
+	Files []string `json:"files"`
+	config
+}
+
+// attributeIndent is a number of levels or "align"; in configuration files
+// it may be written as a number or a string
+type attributeIndent int
+
+func (a *attributeIndent) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if s, err := strconv.Unquote(value); err == nil {
+		value = s
+	}
+	levels, ok := parseAttributeIndent(value)
+	if !ok {
+		return fmt.Errorf("invalid attribute_indent %s: expected a positive number or \"%s\"", data, ATTRIBUTE_INDENT_ALIGN_NAME)
+	}
+	*a = attributeIndent(levels)
+	return nil
+}
+
+func (a attributeIndent) MarshalJSON() ([]byte, error) {
+	if a == fixml.ATTRIBUTE_INDENT_ALIGN {
+		return json.Marshal(ATTRIBUTE_INDENT_ALIGN_NAME)
+	}
+	return json.Marshal(int(a))
+}
+
+// parseAttributeIndent converts an --attribute-indent value to Options.AttributeIndent
+func parseAttributeIndent(value string) (int, bool) {
+	if value == ATTRIBUTE_INDENT_ALIGN_NAME {
+		return fixml.ATTRIBUTE_INDENT_ALIGN, true
+	}
+	levels, err := strconv.Atoi(value)
+	return levels, err == nil && levels > 0
+}
+
+// loadConfig reads a JSON or, by extension, TOML configuration file.
+// Unknown keys are rejected so that typos do not go unnoticed.
+func loadConfig(path string) (*configFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file '%s': %v", path, err)
+	}
+
+	if strings.HasSuffix(path, ".toml") {
+		table, err := parseTOML(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse config file '%s': %v", path, err)
+		}
+		// The decoder below validates TOML and JSON files alike
+		content, _ = json.Marshal(table)
+	}
+
+	cf := &configFile{path: path, dir: filepath.Dir(path)}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cf); err != nil {
+		return nil, fmt.Errorf("could not parse config file '%s': %v", path, err)
+	}
+
+	if err := cf.validate(); err != nil {
+		return nil, fmt.Errorf("could not parse config file '%s': %v", path, err)
+	}
+	for i, o := range cf.Overrides {
+		if len(o.Files) == 0 {
+			return nil, fmt.Errorf("could not parse config file '%s': override %d has no files", path, i+1)
+		}
+		if len(o.Include) > 0 || len(o.Exclude) > 0 {
+			return nil, fmt.Errorf("could not parse config file '%s': include and exclude cannot be overridden", path)
+		}
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("could not parse config file '%s': %v", path, err)
+		}
+	}
+	return cf, nil
+}
+
+// validate checks the values the decoder cannot check by type
+func (c config) validate() error {
+	if c.IndentWidth != nil && *c.IndentWidth < 1 {
+		return fmt.Errorf("indent_width must be positive")
+	}
+	if _, ok := DEDUP_MODES[deref(c.Dedup, "siblings")]; !ok {
+		return fmt.Errorf("invalid dedup %q", *c.Dedup)
+	}
+	for _, expr := range append(c.Only[:len(c.Only):len(c.Only)], c.Skip...) {
+		if _, err := fixml.ParseSelector(expr); err != nil {
+			return fmt.Errorf("invalid selector %q: %v", expr, err)
+		}
+	}
+	for name, policy := range map[string]*string{"comments": c.Comments, "cdata": c.CData, "processing_instructions": c.ProcInsts} {
+		if _, ok := NODE_POLICIES[deref(policy, "keep")]; !ok {
+			return fmt.Errorf("invalid %s %q", name, *policy)
+		}
+	}
+	if _, ok := PROFILES[deref(c.Profile, "none")]; !ok {
+		return fmt.Errorf("invalid profile %q", *c.Profile)
+	}
+	if _, ok := VERSION_POLICIES[deref(c.VersionPolicy, "report")]; !ok {
+		return fmt.Errorf("invalid version_policy %q", *c.VersionPolicy)
+	}
+	if _, ok := EOL_MODES[deref(c.EOL, "lf")]; !ok {
+		return fmt.Errorf("invalid eol %q", *c.EOL)
+	}
+	if _, ok := FINAL_NEWLINE_MODES[deref(c.FinalNewline, "ensure")]; !ok {
+		return fmt.Errorf("invalid final_newline %q", *c.FinalNewline)
+	}
+	for id := range c.Warnings {
+		if _, ok := fixml.LookupRule(id); !ok {
+			return fmt.Errorf("unknown rule %q in warnings", id)
+		}
+	}
+	return nil
+}
+
+func deref(s *string, fallback string) string {
+	if s == nil {
+		return fallback
+	}
+	return *s
+}
+
+// override returns c with every setting that is set in o replaced.
+// Warnings are merged by ID.
+func (c config) override(o config) config {
+	if o.IndentWidth != nil {
+		c.IndentWidth = o.IndentWidth
+	}
+	if o.IndentTabs != nil {
+		c.IndentTabs = o.IndentTabs
+	}
+	if o.AttributeIndent != nil {
+		c.AttributeIndent = o.AttributeIndent
+	}
+	if o.Dedup != nil {
+		c.Dedup = o.Dedup
+	}
+	if o.DedupExempt != nil {
+		c.DedupExempt = o.DedupExempt
+	}
+	if o.PreserveSpace != nil {
+		c.PreserveSpace = o.PreserveSpace
+	}
+	if o.Only != nil {
+		c.Only = o.Only
+	}
+	if o.Skip != nil {
+		c.Skip = o.Skip
+	}
+	if o.Comments != nil {
+		c.Comments = o.Comments
+	}
+	if o.CData != nil {
+		c.CData = o.CData
+	}
+	if o.ProcInsts != nil {
+		c.ProcInsts = o.ProcInsts
+	}
+	if o.Warnings != nil {
+		merged := make(map[string]bool, len(c.Warnings)+len(o.Warnings))
+		for id, enabled := range c.Warnings {
+			merged[id] = enabled
+		}
+		for id, enabled := range o.Warnings {
+			merged[id] = enabled
+		}
+		c.Warnings = merged
+	}
+	if o.Profile != nil {
+		c.Profile = o.Profile
+	}
+	if o.VersionPolicy != nil {
+		c.VersionPolicy = o.VersionPolicy
+	}
+	if o.EOL != nil {
+		c.EOL = o.EOL
+	}
+	if o.FinalNewline != nil {
+		c.FinalNewline = o.FinalNewline
+	}
+	if o.Include != nil {
+		c.Include = o.Include
+	}
+	if o.Exclude != nil {
+		c.Exclude = o.Exclude
+	}
+	return c
+}
+
+// apply copies the settings that are set into opts
+func (c config) apply(opts *fixml.Options) {
+	if c.IndentWidth != nil {
+		opts.IndentWidth = *c.IndentWidth
+	}
+	if c.IndentTabs != nil {
+		opts.IndentTabs = *c.IndentTabs
+	}
+	if c.AttributeIndent != nil {
+		opts.AttributeIndent = int(*c.AttributeIndent)
+	}
+	if c.Dedup != nil {
+		opts.Dedup = DEDUP_MODES[*c.Dedup]
+	}
+	opts.DedupExempt = c.DedupExempt
+	opts.PreserveSpace = c.PreserveSpace
+	opts.Only = c.Only
+	opts.Skip = c.Skip
+	if c.Comments != nil {
+		opts.Comments = NODE_POLICIES[*c.Comments]
+	}
+	if c.CData != nil {
+		opts.CData = NODE_POLICIES[*c.CData]
+	}
+	if c.ProcInsts != nil {
+		opts.ProcInsts = NODE_POLICIES[*c.ProcInsts]
+	}
+	opts.Warnings = c.Warnings
+	if c.Profile != nil {
+		opts.Profile = PROFILES[*c.Profile]
+	}
+	if c.VersionPolicy != nil {
+		opts.VersionPolicy = VERSION_POLICIES[*c.VersionPolicy]
+	}
+	if c.EOL != nil {
+		opts.EOL = EOL_MODES[*c.EOL]
+	}
+	if c.FinalNewline != nil {
+		opts.FinalNewline = FINAL_NEWLINE_MODES[*c.FinalNewline]
+	}
+}
+
+// configCache loads every configuration file once, however many inputs and
+// workers share it
+type configCache struct {
+	mu    sync.Mutex
+	files map[string]*configFile // By path; nil when the file does not exist
+	dirs  map[string]string      // Configuration file found from a directory, or ""
+}
+
+func newConfigCache() *configCache {
+	return &configCache{files: make(map[string]*configFile), dirs: make(map[string]string)}
+}
+
+// load returns the configuration file at path, reading it on first use
+func (cc *configCache) load(path string) (*configFile, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cf, ok := cc.files[path]; ok {
+		return cf, nil
+	}
+	cf, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cc.files[path] = cf
+	return cf, nil
+}
+
+// find returns the path of the nearest configuration file in dir or one of
+// its parents, or "" when there is none
+func (cc *configCache) find(dir string) string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	var visited []string
+	found := ""
+	for {
+		if cached, ok := cc.dirs[dir]; ok {
+			found = cached
+			break
+		}
+		visited = append(visited, dir)
+		for _, name := range CONFIG_FILES {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+				found = candidate
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if found != "" || parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	for _, d := range visited {
+		cc.dirs[d] = found
+	}
+	return found
+}
+
+// configFor resolves the effective configuration of one input: the nearest
+// configuration file (or --config), its overrides matching the input, then
+// the command-line options. It also returns the configuration file used.
+// Directories are resolved from themselves, other inputs from their directory.
+func (args Args) configFor(input string) (config, string, error) {
+	path := args.configFile
+	abs, err := filepath.Abs(input)
+	if input == STDIO_FILE || err != nil {
+		abs, _ = os.Getwd()
+		abs = filepath.Join(abs, STDIO_FILE)
+	}
+	if path == "" {
+		dir := filepath.Dir(abs)
+		if info, err := os.Stat(abs); err == nil && info.IsDir() {
+			dir = abs
+		}
+		path = args.configs.find(dir)
+	}
+
+	var effective config
+	if path != "" {
+		cf, err := args.configs.load(path)
+		if err != nil {
+			return effective, path, err
+		}
+		effective = cf.config
+
+		configDir, _ := filepath.Abs(cf.dir)
+		rel, err := filepath.Rel(configDir, abs)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			rel = filepath.ToSlash(rel)
+			for _, o := range cf.Overrides {
+				if matchAny(o.Files, rel) {
+					effective = effective.override(o.config)
+				}
+			}
+		}
+	}
+	return effective.override(args.settings), path, nil
+}
+
+// printConfig writes the effective configuration of every input as JSON,
+// with defaults filled in for settings nobody set
+func printConfig(args Args, out io.Writer) error {
+	type resolved struct {
+		File       string `json:"file"`
+		ConfigFile string `json:"config_file"` // Empty when no configuration file applies
+		config
+	}
+
+	var all []resolved
+	for _, input := range args.paths {
+		cfg, path, err := args.configFor(input)
+		if err != nil {
+			return err
+		}
+		all = append(all, resolved{File: input, ConfigFile: path, config: withDefaults(cfg)})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if len(all) == 1 {
+		return encoder.Encode(all[0])
+	}
+	return encoder.Encode(all)
+}
+
+// withDefaults sets every unset setting to the value fixml uses for it
+func withDefaults(c config) config {
+	var opts fixml.Options
+	c.apply(&opts)
+
+	width := opts.IndentWidth
+	if width == 0 {
+		width = fixml.DEFAULT_INDENT_WIDTH
+	}
+	levels := attributeIndent(opts.AttributeIndent)
+	if levels == 0 {
+		levels = 1
+	}
+	defaults := config{
+		IndentWidth:     &width,
+		IndentTabs:      &opts.IndentTabs,
+		AttributeIndent: &levels,
+		Dedup:           ptr(modeName(DEDUP_MODES, opts.Dedup)),
+		DedupExempt:     []string{},
+		PreserveSpace:   []string{},
+		Only:            []string{},
+		Skip:            []string{},
+		Comments:        ptr(modeName(NODE_POLICIES, opts.Comments)),
+		CData:           ptr(modeName(NODE_POLICIES, opts.CData)),
+		ProcInsts:       ptr(modeName(NODE_POLICIES, opts.ProcInsts)),
+		Warnings:        map[string]bool{},
+		Profile:         ptr(modeName(PROFILES, opts.Profile)),
+		VersionPolicy:   ptr(modeName(VERSION_POLICIES, opts.VersionPolicy)),
+		EOL:             ptr(modeName(EOL_MODES, opts.EOL)),
+		FinalNewline:    ptr(modeName(FINAL_NEWLINE_MODES, opts.FinalNewline)),
+		Include:         DEFAULT_INCLUDE,
+		Exclude:         []string{},
+	}
+	for _, r := range fixml.Rules() {
+		defaults.Warnings[r.Info().ID] = !r.Info().Optional
+	}
+	return defaults.override(c)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// modeName returns the setting value that selects mode
+func modeName[M comparable](modes map[string]M, mode M) string {
+	names := make([]string, 0, len(modes))
+	for name := range modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if modes[name] == mode {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
+)
+
+// Indentation settings come from the nearest configuration file, its
+// overrides, then the command line
+func TestIndentationSettings(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "vendor")
+	os.Mkdir(sub, fixml.DIR_PERMISSIONS)
+	toml := "indent_width = 4\nattribute_indent = \"align\"\n\n[[overrides]]\nfiles = [\"*.props\"]\nindent_tabs = true\nattribute_indent = 2\n"
+	os.WriteFile(filepath.Join(dir, ".fixml.toml"), []byte(toml), fixml.FILE_PERMISSIONS)
+	os.WriteFile(filepath.Join(sub, ".fixml.json"), []byte(`{"indent_width": 3}`), fixml.FILE_PERMISSIONS)
+
+	type indentation struct {
+		width, attributes int
+		tabs              bool
+	}
+	tests := []struct {
+		name     string
+		file     string
+		settings config
+		want     indentation
+	}{
+		{"configuration file", "app.csproj", config{}, indentation{4, fixml.ATTRIBUTE_INDENT_ALIGN, false}},
+		{"override", "Directory.Build.props", config{}, indentation{4, 2, true}},
+		{"nearest configuration file", "vendor/app.config", config{}, indentation{3, 0, false}},
+		{"command line", "Directory.Build.props", config{IndentWidth: ptr(8), IndentTabs: ptr(false)}, indentation{8, 2, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := Args{configs: newConfigCache(), settings: tt.settings}
+			opts, err := args.options(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatalf("options failed: %v", err)
+			}
+			if got := (indentation{opts.IndentWidth, opts.AttributeIndent, opts.IndentTabs}); got != tt.want {
+				t.Errorf("indentation = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndentationSettingErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`{"indent_width": 0}`, "indent_width must be positive"},
+		{`{"attribute_indent": 0}`, `invalid attribute_indent 0: expected a positive number or "align"`},
+		{`{"attribute_indent": "left"}`, `invalid attribute_indent "left"`},
+		{`{"indent_tabs": "yes"}`, "cannot unmarshal string"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".fixml.json")
+		os.WriteFile(path, []byte(tt.content), fixml.FILE_PERMISSIONS)
+		if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadConfig(%s) error = %v, want %q", tt.content, err, tt.want)
+		}
+	}
+}
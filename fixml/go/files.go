@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
+)
+
+// DEFAULT_INCLUDE lists the file patterns picked up from directories when no --include is given
+var DEFAULT_INCLUDE = []string{
+	"*.xml", "*.csproj", "*.vbproj", "*.fsproj", "*.props", "*.targets", "*.nuspec", "*.config", "*.resx",
+}
+
+// expandPaths turns the command-line paths into the list of files to process.
+// Plain files are taken as given; directories are walked recursively and
+// filtered through the include/exclude settings of their configuration or
+// the command line; glob patterns (with ** matching any number of
+// directories) select files themselves and honour exclude.
+// The boolean result reports whether any directory or glob was expanded.
+func expandPaths(args Args) ([]string, bool, error) {
+	var files []string
+	expanded := false
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, p := range args.paths {
+		if hasGlobMeta(p) {
+			expanded = true
+			pattern := path.Clean(filepath.ToSlash(p))
+			root := globRoot(pattern)
+			cfg, _, err := args.configFor(root)
+			if err != nil {
+				return nil, expanded, err
+			}
+			err = walkFiles(root, cfg.Exclude, func(file, rel string) {
+				if matchGlob(pattern, path.Clean(filepath.ToSlash(file))) {
+					add(file)
+				}
+			})
+			if err != nil {
+				return nil, expanded, err
+			}
+			continue
+		}
+
+		info, err := os.Stat(p)
+		if err != nil || !info.IsDir() {
+			add(p) // Missing files are reported when they are processed
+			continue
+		}
+
+		expanded = true
+		cfg, _, err := args.configFor(p)
+		if err != nil {
+			return nil, expanded, err
+		}
+		include := cfg.Include
+		if len(include) == 0 {
+			include = DEFAULT_INCLUDE
+		}
+		err = walkFiles(p, cfg.Exclude, func(file, rel string) {
+			if matchAny(include, rel) {
+				add(file)
+			}
+		})
+		if err != nil {
+			return nil, expanded, err
+		}
+	}
+
+	return files, expanded, nil
+}
+
+// walkFiles calls visit for every regular file below root that is not
+// excluded. Hidden directories and fixml's own output files are skipped.
+// rel is the slash-separated path relative to root.
+func walkFiles(root string, exclude []string, visit func(file, rel string)) error {
+	return filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("could not read directory '%s': %v", file, err)
+		}
+
+		rel, _ := filepath.Rel(root, file)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if file != root && (strings.HasPrefix(d.Name(), ".") || matchAny(exclude, rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || isGeneratedFile(d.Name()) || matchAny(exclude, rel) {
+			return nil
+		}
+		visit(file, rel)
+		return nil
+	})
+}
+
+// isGeneratedFile recognizes files written by fixml itself (.organized
+// outputs, replace temp files and backups)
+func isGeneratedFile(name string) bool {
+	return strings.Contains(name, ".organized.") || strings.HasSuffix(name, ".organized") ||
+		strings.HasSuffix(name, fixml.REPLACE_TEMP_SUFFIX) || strings.HasSuffix(name, BACKUP_SUFFIX)
+}
+
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globRoot returns the directory a glob pattern has to be walked from:
+// its leading segments up to the first one containing a wildcard
+func globRoot(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	i := 0
+	for i < len(segments)-1 && !hasGlobMeta(segments[i]) {
+		i++
+	}
+	root := strings.Join(segments[:i], "/")
+	if root == "" {
+		if strings.HasPrefix(pattern, "/") {
+			return "/"
+		}
+		return "."
+	}
+	return filepath.FromSlash(root)
+}
+
+// matchAny reports whether rel matches one of the patterns. Patterns without
+// a slash are matched against the base name, like .gitignore entries.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if matchGlob(path.Clean(pattern), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated path against a pattern in which
+// "**" stands for zero or more whole path segments
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -1,569 +1,834 @@
 // FIXML - High-Performance XML Processor (Go Implementation)
 //
 // This implementation balances performance with Go's idioms:
-// - Object pooling for strings.Builder instances to reduce GC pressure
 // - Buffered I/O to avoid Scanner token limits on large files
-// - Fast byte-level operations for whitespace handling
+// - Streaming tokenizer instead of per-line tag heuristics; tags are scanned
+//   in bulk from the read buffer and their attributes once for all rules
+// - Fast byte-level operations for whitespace and line ending handling
 // - Pre-allocated hash maps and string caches for consistent performance
 //
 // Performance Characteristics:
 // - Time Complexity: O(n) where n = input file size
-// - Space Complexity: O(n + d) where d = unique elements
-// - Benchmark Results: 18.13ms average (2nd fastest, excellent balance)
+// - Space Complexity: O(d) where d = unique elements; input and output are
+//   streamed, except with --organize, --profile msbuild, --strict, --verify
+//   and --canonical, which hold the document in memory
+//
+// The processing itself lives in pkg/fixml; this file is the command-line
+// wrapper that parses arguments and prints the human-readable report.
 
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
-	"hash/fnv"
 	"io"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
 )
 
-const USAGE = `Usage: fixml [--replace] [--fix-warnings] <xml-file>
+const USAGE = `Usage: fixml [options] <xml-file | directory | glob | -> ...
+       fixml restore [--backup-dir <dir>]  Undo the last --replace run made with --backup
+  --organize, -o      Apply logical organization (group related sibling elements)
+  --organize-order <names>  Comma-separated element names to group, in group order
   --replace, -r       Replace original file
+  --backup <mode>     With --replace, keep the originals: bak (file.bak next to each file)
+                      or dir (copies in the backup directory); enables fixml restore
+  --backup-dir <dir>  Where replace runs are journaled for restore (default: .fixml-backups)
   --fix-warnings, -f  Fix XML warnings
+  --strict            Write no output when the input is not well-formed
+  --verify            Write no output unless it parses to the same XML tree as the input
+                      (besides whitespace and removed duplicates) and reformats unchanged
+  --canonical <mode>  Write the W3C canonical form of the result instead: c14n (Canonical
+                      XML 1.0) or exc-c14n (exclusive canonicalization), without comments
+  --global-dedup      Remove repeated elements anywhere in the document, not only among siblings
+  --dedup <mode>      Deduplication scope: siblings (default), global or off
+  --preserve-space <names>  Comma-separated element names whose content is kept exactly as
+                      written, like elements with xml:space="preserve"
+  --only <path>       Reindent and deduplicate only elements matching this path (repeatable);
+                      paths use an XPath subset: /a/b, //b, *, [@attr] and [@attr='value']
+  --skip <path>       Leave elements matching this path as written (repeatable)
+  --comments <policy>  Comments are never removed as duplicates (keep, default), or
+                      deduplicated along with their line or element (dedup)
+  --cdata <policy>    The same for CDATA sections
+  --processing-instructions <policy>  The same for processing instructions
+  --profile <name>    Document profile: none (default), or msbuild to deduplicate PackageReference
+                      items by package ID and detect conflicting versions
+  --version-policy <policy>  With --profile msbuild, conflicting versions are reported (report,
+                      default) or resolved by keeping the highest, lowest or first one
+  --keep-encoding     Write UTF-16, ISO-8859-1 and windows-1252 input back in its encoding (default: UTF-8)
+  --eol <mode>        Line endings: lf (default), crlf, or auto to keep the input's dominant one
+  --final-newline <mode>  End of output: ensure (default), keep as in the input, or remove
+  --indent-width <n>  Spaces per nesting level (default: 2)
+  --indent-tabs       Indent with tabs instead of spaces
+  --attribute-indent <n|align>  Indent attributes continued on later lines n levels past
+                      their tag (default: 1), or align them with the tag's first attribute
+  --enable <rules>    Run these comma-separated lint rules, including optional ones
+  --disable <rules>   Do not report or fix these comma-separated lint rules
+  --list-rules        List the lint rules and exit
+  --config <file>     Use this settings file instead of the nearest .fixml.json / .fixml.toml
+  --print-config      Print the effective settings of each input as JSON and exit
+  --stdout            Write formatted XML to stdout (reads stdin without <xml-file>)
+  --check             Write nothing; exit 2 if a file is not already formatted
+  --diff              Write nothing; print a unified diff of the changes
+  --color             Colorize --diff output
+  --report json       Print a JSON report instead of messages (to stderr when stdout carries XML or a diff)
+  --include <glob>    Files to pick up from directories (repeatable, default: common XML/MSBuild extensions)
+  --exclude <glob>    Files and directories to skip (repeatable)
+  --jobs, -j <n>      Files processed in parallel (default: GOMAXPROCS)
+  -                   Read from stdin and write to stdout
   Default: preserve original structure, fix indentation/deduplication only
+  Globs support ** for any number of directories; quote them to bypass the shell
 `
 
-// Standard constants - consistent across all implementations
-const XML_DECLARATION = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
-const MAX_INDENT_LEVELS = 64           // Maximum nesting depth supported
-const ESTIMATED_LINE_LENGTH = 50       // Average characters per line estimate
-const MIN_HASH_CAPACITY = 256          // Minimum deduplication hash capacity
-const MAX_HASH_CAPACITY = 4096         // Maximum deduplication hash capacity
-const WHITESPACE_THRESHOLD = 32        // ASCII values <= this are whitespace
-const FILE_PERMISSIONS = 0644          // Standard file permissions
-const IO_CHUNK_SIZE = 65536           // 64KB chunks for I/O operations
-
-// Object pool for reusing strings.Builder instances
-// Reduces garbage collection pressure during intensive string building
-// Critical optimization for processing large XML files
-var builderPool = sync.Pool{
-	New: func() interface{} {
-		return &strings.Builder{}
-	},
+// STDIO_FILE is the file argument that selects stdin/stdout filter mode
+const STDIO_FILE = "-"
+
+// EOL_MODES maps the values of --eol to line ending modes
+var EOL_MODES = map[string]fixml.EOLMode{
+	"lf":   fixml.EOLLF,
+	"crlf": fixml.EOLCRLF,
+	"auto": fixml.EOLAuto,
+}
+
+// CANONICAL_MODES maps the values of --canonical to canonicalization modes
+var CANONICAL_MODES = map[string]fixml.CanonicalMode{
+	"c14n":     fixml.CanonicalC14N,
+	"exc-c14n": fixml.CanonicalExclusive,
+}
+
+// FINAL_NEWLINE_MODES maps the values of --final-newline to final newline policies
+var FINAL_NEWLINE_MODES = map[string]fixml.FinalNewlineMode{
+	"ensure": fixml.FinalNewlineEnsure,
+	"keep":   fixml.FinalNewlineKeep,
+	"remove": fixml.FinalNewlineRemove,
 }
 
+// MAX_WARNINGS_SHOWN is the number of findings printed per lint rule
+const MAX_WARNINGS_SHOWN = 5
+
+// Exit codes
+const EXIT_ERROR = 1        // Invalid arguments, processing failure or malformed XML
+const EXIT_CHECK_FAILED = 2 // --check found input that would be changed
+
 // Command-line argument structure
 // Mirrors interface across all language implementations for consistency
 type Args struct {
-	replace     bool
-	fixWarnings bool
-	file        string
+	organize     bool
+	order        []string
+	replace      bool
+	backup       string
+	backupDir    string
+	backups      *backupRun // Journal of this run's backups; nil without --backup
+	restore      bool
+	fixWarnings  bool
+	strict       bool
+	verify       bool
+	canonical    fixml.CanonicalMode
+	keepEncoding bool
+	configFile   string
+	settings     config       // Set on the command line; overrides configuration files
+	configs      *configCache // Configuration files found so far
+	printConfig  bool
+	listRules    bool
+	stdout       bool
+	check        bool
+	diff         bool
+	color        bool
+	jobs         int
+	report       string
+	paths        []string
 }
 
+func usage() {
+	fmt.Print(USAGE)
+	os.Exit(EXIT_ERROR)
+}
 
 func parseArgs() Args {
-	args := Args{}
-	
-	for _, arg := range os.Args[1:] {
-		switch arg {
+	args := Args{jobs: runtime.GOMAXPROCS(0), configs: newConfigCache(), backupDir: BACKUP_DIR}
+
+	argv := os.Args[1:]
+	if len(argv) > 0 && argv[0] == "restore" {
+		args.restore = true
+		argv = argv[1:]
+	}
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+
+		// Options taking a value accept both "--name value" and "--name=value"
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--include", "--exclude", "--jobs", "-j", "--report", "--organize-order", "--eol", "--final-newline",
+			"--indent-width", "--attribute-indent", "--config", "--dedup", "--enable", "--disable",
+			"--backup", "--backup-dir", "--profile", "--version-policy", "--comments", "--cdata",
+			"--processing-instructions", "--preserve-space", "--canonical", "--only", "--skip":
+			if !hasValue {
+				if i+1 >= len(argv) {
+					usage()
+				}
+				i++
+				value = argv[i]
+			}
+		}
+
+		switch name {
+		case "--organize", "-o":
+			args.organize = true
+		case "--organize-order":
+			args.order = splitList(value)
 		case "--replace", "-r":
 			args.replace = true
+		case "--backup":
+			if value != BACKUP_MODE_BAK && value != BACKUP_MODE_DIR {
+				usage()
+			}
+			args.backup = value
+		case "--backup-dir":
+			args.backupDir = value
 		case "--fix-warnings", "-f":
 			args.fixWarnings = true
+		case "--strict":
+			args.strict = true
+		case "--verify":
+			args.verify = true
+		case "--canonical":
+			mode, ok := CANONICAL_MODES[value]
+			if !ok {
+				usage()
+			}
+			args.canonical = mode
+		case "--global-dedup":
+			args.settings.Dedup = ptr("global")
+		case "--dedup":
+			if _, ok := DEDUP_MODES[value]; !ok {
+				usage()
+			}
+			args.settings.Dedup = &value
+		case "--preserve-space":
+			args.settings.PreserveSpace = splitList(value)
+		case "--only", "--skip":
+			if _, err := fixml.ParseSelector(value); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid %s path %q: %v\n", name, value, err)
+				os.Exit(EXIT_ERROR)
+			}
+			if name == "--only" {
+				args.settings.Only = append(args.settings.Only, value)
+			} else {
+				args.settings.Skip = append(args.settings.Skip, value)
+			}
+		case "--comments", "--cdata", "--processing-instructions":
+			if _, ok := NODE_POLICIES[value]; !ok {
+				usage()
+			}
+			switch name {
+			case "--comments":
+				args.settings.Comments = &value
+			case "--cdata":
+				args.settings.CData = &value
+			default:
+				args.settings.ProcInsts = &value
+			}
+		case "--profile":
+			if _, ok := PROFILES[value]; !ok {
+				usage()
+			}
+			args.settings.Profile = &value
+		case "--version-policy":
+			if _, ok := VERSION_POLICIES[value]; !ok {
+				usage()
+			}
+			args.settings.VersionPolicy = &value
+		case "--keep-encoding":
+			args.keepEncoding = true
+		case "--eol":
+			if _, ok := EOL_MODES[value]; !ok {
+				usage()
+			}
+			args.settings.EOL = &value
+		case "--final-newline":
+			if _, ok := FINAL_NEWLINE_MODES[value]; !ok {
+				usage()
+			}
+			args.settings.FinalNewline = &value
+		case "--indent-width":
+			width, err := strconv.Atoi(value)
+			if err != nil || width < 1 {
+				usage()
+			}
+			args.settings.IndentWidth = &width
+		case "--indent-tabs":
+			args.settings.IndentTabs = ptr(true)
+		case "--attribute-indent":
+			levels, ok := parseAttributeIndent(value)
+			if !ok {
+				usage()
+			}
+			indent := attributeIndent(levels)
+			args.settings.AttributeIndent = &indent
+		case "--enable", "--disable":
+			for _, id := range splitList(value) {
+				if _, ok := fixml.LookupRule(id); !ok {
+					fmt.Fprintf(os.Stderr, "Error: unknown rule %q; --list-rules shows them all\n", id)
+					os.Exit(EXIT_ERROR)
+				}
+				if args.settings.Warnings == nil {
+					args.settings.Warnings = map[string]bool{}
+				}
+				args.settings.Warnings[id] = name == "--enable"
+			}
+		case "--list-rules":
+			args.listRules = true
+		case "--config":
+			args.configFile = value
+		case "--print-config":
+			args.printConfig = true
+		case "--stdout":
+			args.stdout = true
+		case "--check":
+			args.check = true
+		case "--diff":
+			args.diff = true
+		case "--color":
+			args.color = true
+		case "--report":
+			if value != REPORT_JSON {
+				usage()
+			}
+			args.report = value
+		case "--include":
+			args.settings.Include = append(args.settings.Include, value)
+		case "--exclude":
+			args.settings.Exclude = append(args.settings.Exclude, value)
+		case "--jobs", "-j":
+			jobs, err := strconv.Atoi(value)
+			if err != nil || jobs < 1 {
+				usage()
+			}
+			args.jobs = jobs
+		case STDIO_FILE:
+			args.paths = append(args.paths, STDIO_FILE)
 		default:
-			if args.file == "" && !strings.HasPrefix(arg, "-") {
-				args.file = arg
+			if !strings.HasPrefix(arg, "-") {
+				args.paths = append(args.paths, arg)
 			}
 		}
 	}
-	
-	if args.file == "" {
-		fmt.Print(USAGE)
-		os.Exit(1)
+
+	// Filter mode: no file with --stdout reads stdin, "-" implies --stdout
+	if len(args.paths) == 0 && args.stdout {
+		args.paths = []string{STDIO_FILE}
+	}
+	for _, p := range args.paths {
+		if p == STDIO_FILE {
+			args.stdout = true
+		}
+	}
+
+	if args.listRules || (args.restore && len(args.paths) == 0) {
+		return args
+	}
+	if args.restore || (args.backup != "" && !args.replace) {
+		usage()
+	}
+	if len(args.paths) == 0 && args.printConfig {
+		args.paths = []string{"."}
+	}
+	if len(args.paths) == 0 || (args.stdout && (args.replace || len(args.paths) > 1)) {
+		usage()
+	}
+
+	// An explicit configuration file is checked up front; discovered ones
+	// are reported with the inputs they apply to
+	if args.configFile != "" {
+		if _, err := args.configs.load(args.configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(EXIT_ERROR)
+		}
 	}
-	
+
 	return args
 }
 
-// Optimized O(n) single-pass content cleaning
-func cleanContent(content string) string {
-	if len(content) == 0 {
-		return content
-	}
-	
-	// Pre-allocate builder with capacity
-	var result strings.Builder
-	result.Grow(len(content))
-	
-	i := 0
-	// Remove BOM if present
-	if len(content) >= 3 && content[0] == '\xEF' && content[1] == '\xBB' && content[2] == '\xBF' {
-		i = 3
-	}
-	
-	// Single-pass line ending normalization
-	for i < len(content) {
-		if i < len(content)-1 && content[i] == '\r' && content[i+1] == '\n' {
-			result.WriteByte('\n')
-			i += 2
-		} else if content[i] == '\r' {
-			result.WriteByte('\n')
-			i++
-		} else {
-			result.WriteByte(content[i])
-			i++
+// splitList splits a comma-separated option value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
-	
-	return result.String()
+	return items
 }
 
-
-func processFile(args Args) error {
-	content, err := os.ReadFile(args.file)
+// options returns the processing options of one input: the command-line
+// flags combined with its effective configuration
+func (args Args) options(file string) (fixml.Options, error) {
+	opts := fixml.Options{
+		Replace:          args.replace,
+		FixWarnings:      args.fixWarnings,
+		Organize:         args.organize,
+		OrganizeOrder:    args.order,
+		Strict:           args.strict,
+		Verify:           args.verify,
+		Canonical:        args.canonical,
+		KeepEncoding:     args.keepEncoding,
+		ReportDuplicates: args.report != "",
+	}
+	cfg, _, err := args.configFor(file)
 	if err != nil {
-		return fmt.Errorf("could not read file '%s': %v", args.file, err)
-	}
-	
-	cleaned := cleanContent(string(content))
-	hasXMLDecl := strings.Contains(cleaned, "<?xml")
-	
-	if !hasXMLDecl {
-		fmt.Println("⚠️  XML Best Practice Warnings:")
-		fmt.Println("  [XML] Missing XML declaration")
-		fmt.Println("    Fix: Add <?xml version=\"1.0\" encoding=\"utf-8\"?> at the top")
-		fmt.Println()
-		
-		if !args.fixWarnings {
-			fmt.Println("Use --fix-warnings flag to automatically apply fixes")
-			fmt.Println()
-		}
+		return opts, err
+	}
+	cfg.apply(&opts)
+	if args.backups != nil {
+		opts.Backup = args.backups.path(file)
 	}
-	
-	// Just process as text to preserve original structure and avoid XML parsing issues
-	return processAsText(args, cleaned, hasXMLDecl)
+	return opts, nil
 }
 
-func processAsText(args Args, content string, hasXMLDecl bool) error {
-	var output bytes.Buffer
-	output.Grow(len(content) + 100)
-	
-	shouldStripXMLDeclaration := false
-	if args.fixWarnings && (shouldStripXMLDeclaration || !hasXMLDecl) {
-		output.WriteString(XML_DECLARATION)
-		if !hasXMLDecl {
-			fmt.Println("🔧 Applied fixes:")
-			fmt.Println("  ✓ Added XML declaration")
-			fmt.Println()
+// messages returns where human-readable messages go: out, or nowhere when
+// a report replaces them
+func (args Args) messages(out io.Writer) io.Writer {
+	if args.report != "" {
+		return io.Discard
+	}
+	return out
+}
+
+// reportOutput returns where the report goes: stdout unless stdout already
+// carries formatted XML or a diff
+func (args Args) reportOutput() io.Writer {
+	if args.stdout || args.diff {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// fileRun holds the outcome of one file processed by the worker pool.
+// Messages are buffered so output of concurrent files never interleaves.
+type fileRun struct {
+	file    string
+	res     fixml.Result
+	err     error
+	elapsed time.Duration
+	log     bytes.Buffer
+	done    chan struct{}
+}
+
+// run processes every input and returns the process exit code
+func run(args Args) int {
+	started := time.Now()
+	if args.restore {
+		if err := restoreLastRun(args.backupDir, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return EXIT_ERROR
 		}
+		return 0
+	}
+	if args.listRules {
+		printRules(os.Stdout)
+		return 0
 	}
-	
-	indentLevel := 0
-	// Pre-allocate map with estimated size to avoid rehashing
-	estimatedElements := len(content) / ESTIMATED_LINE_LENGTH // Estimate based on standard line length
-	if estimatedElements < MIN_HASH_CAPACITY {
-		estimatedElements = MIN_HASH_CAPACITY
-	}
-	if estimatedElements > MAX_HASH_CAPACITY {
-		estimatedElements = MAX_HASH_CAPACITY
-	}
-	seenElements := make(map[uint64]bool, estimatedElements)
-	duplicatesRemoved := 0
-	
-	// Pre-cache common indentation strings (standardized across all implementations)
-	indentCache := make([]string, MAX_INDENT_LEVELS+1) // Support up to MAX_INDENT_LEVELS
-	for i := 0; i < len(indentCache); i++ {
-		indentCache[i] = strings.Repeat("  ", i)
-	}
-	
-	// Process lines with a buffered reader to avoid Scanner token limits
-	reader := bufio.NewReader(strings.NewReader(content))
-	for {
-		line, err := reader.ReadString('\n')
-		if len(line) > 0 {
-			// Trim only the trailing newline added by ReadString
-			if line[len(line)-1] == '\n' {
-				line = line[:len(line)-1]
+	if args.printConfig {
+		if err := printConfig(args, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return EXIT_ERROR
+		}
+		return 0
+	}
+	if args.stdout && !args.check && !args.diff {
+		res, err := processStdio(args, args.paths[0])
+		if args.report != "" {
+			elapsed := time.Since(started)
+			writeReport(args.reportOutput(), []fileReport{newFileReport(args.paths[0], res, err, elapsed)}, elapsed)
+		} else {
+			printSyntaxErrors(args.paths[0], res)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			}
-			trimmed := fastTrimSpace(line)
-			if trimmed != "" {
-				// Never strip XML declaration lines - always preserve them
-				// Fast container detection - simple tags without spaces (no attributes)
-				isContainer := false
-				if len(trimmed) > 2 && trimmed[0] == '<' && trimmed[len(trimmed)-1] == '>' {
-					hasSpace := false
-					for i := 1; i < len(trimmed)-1; i++ {
-						if trimmed[i] == ' ' || trimmed[i] == '\t' {
-							hasSpace = true
-							break
-						}
-					}
-					isContainer = !hasSpace
-				}
-				// Deduplication only for non-container lines
-				if !isContainer {
-					semanticHash := computeSemanticHash(trimmed)
-					if seenElements[semanticHash] {
-						duplicatesRemoved++
-						continue // Skip duplicate line - much cleaner than goto
-					}
-					seenElements[semanticHash] = true
-				}
-				// Simplified tag detection
-				isClosingTag := len(trimmed) >= 2 && trimmed[0] == '<' && trimmed[1] == '/'
-				isOpeningTag := false
-				
-				// Simple opening tag detection - avoid expensive checks when possible
-				if len(trimmed) > 0 && trimmed[0] == '<' && 
-				   !(len(trimmed) >= 2 && (trimmed[1] == '/' || trimmed[1] == '!' || trimmed[1] == '?')) &&
-				   !strings.Contains(trimmed, "/>") {
-					// Only call isSelfContained for potential opening tags
-					isOpeningTag = !isSelfContained(trimmed)
-				}
-				// Adjust indent level for closing tags BEFORE writing the line
-				if isClosingTag {
-					indentLevel = max(0, indentLevel-1)
-				}
-				// Apply consistent 2-space indentation using cached strings with optimized writes
-				if indentLevel < len(indentCache) {
-					output.WriteString(indentCache[indentLevel])
-				} else {
-					output.WriteString(strings.Repeat("  ", indentLevel)) // Fallback for deep nesting
-				}
-				output.WriteString(trimmed)
-				output.WriteByte('\n')
-				// Adjust indent level for opening tags AFTER writing the line
-				if isOpeningTag {
-					indentLevel++
-				}
+		}
+		if err != nil || len(res.Errors) > 0 {
+			return EXIT_ERROR
+		}
+		return 0
+	}
+
+	files, expanded, err := expandPaths(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return EXIT_ERROR
+	}
+
+	// Backups are journaled per run so fixml restore can undo it
+	if args.backup != "" && !args.check && !args.diff {
+		if args.backups, err = newBackupRun(args.backupDir, args.backup); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return EXIT_ERROR
+		}
+		defer args.backups.finish()
+	}
+
+	runs := make([]*fileRun, len(files))
+	for i, file := range files {
+		runs[i] = &fileRun{file: file, done: make(chan struct{})}
+	}
+
+	// Bounded worker pool; results are printed in input order as they complete
+	jobs := make(chan *fileRun)
+	workers := args.jobs
+	if workers > len(runs) {
+		workers = len(runs)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for r := range jobs {
+				start := time.Now()
+				r.res, r.err = processFile(args, r.file, &r.log)
+				r.elapsed = time.Since(start)
+				close(r.done)
 			}
+		}()
+	}
+	go func() {
+		for _, r := range runs {
+			jobs <- r
 		}
-		if err == io.EOF {
-			break
+		close(jobs)
+	}()
+
+	exitCode := 0
+	var reports []fileReport
+	for _, r := range runs {
+		<-r.done
+		os.Stdout.Write(r.log.Bytes())
+		if args.report != "" {
+			reports = append(reports, newFileReport(r.file, r.res, r.err, r.elapsed))
+		} else {
+			printSyntaxErrors(r.file, r.res)
+			if r.err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", r.err)
+			}
 		}
-		if err != nil {
-			return fmt.Errorf("error reading content: %v", err)
+
+		if r.err != nil || len(r.res.Errors) > 0 {
+			exitCode = EXIT_ERROR
+		} else if args.check && r.res.Changed && exitCode == 0 {
+			exitCode = EXIT_CHECK_FAILED
 		}
-		// Continue to next line if there are more to process
-	}
-	
-	// Ensure final newline for consistency with other implementations  
-	if output.Len() > 0 {
-		bytes := output.Bytes()
-		if bytes[len(bytes)-1] != '\n' {
-			output.WriteByte('\n')
+	}
+
+	if args.report != "" {
+		if err := writeReport(args.reportOutput(), reports, time.Since(started)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not write report: %v\n", err)
+			return EXIT_ERROR
 		}
+	} else if expanded || len(runs) > 1 {
+		printSummary(args, runs)
+	}
+	return exitCode
+}
+
+// processFile handles one input according to the selected mode, writing its messages to out
+func processFile(args Args, file string, out io.Writer) (fixml.Result, error) {
+	opts, err := args.options(file)
+	if err != nil {
+		return fixml.Result{}, err
+	}
+	if args.diff {
+		return diffFile(args, file, opts, out)
 	}
-	
-	outputFilename := getOutputFilename(args.file, args.replace)
-	err := os.WriteFile(outputFilename, output.Bytes(), FILE_PERMISSIONS)
+	if args.check {
+		return checkFile(args, file, opts, out)
+	}
+
+	res, err := fixml.ProcessFile(file, opts)
 	if err != nil {
-		return fmt.Errorf("could not write output file: %v", err)
+		return res, err
 	}
-	
+	if res.BackupFile != "" {
+		if err := args.backups.record(file, res.BackupFile); err != nil {
+			return res, err
+		}
+	}
+
+	out = args.messages(out)
+	printWarnings(out, args, res)
+	printFixes(out, res)
+
 	if args.replace {
-		err = os.Rename(outputFilename, args.file)
-		if err != nil {
-			os.Remove(outputFilename)
-			return fmt.Errorf("could not replace original file: %v", err)
+		fmt.Fprintf(out, "Original file replaced: %s", res.OutputFile)
+		if res.BackupFile != "" {
+			fmt.Fprintf(out, " (backup: %s)", res.BackupFile)
 		}
-		fmt.Printf("Original file replaced: %s", args.file)
 	} else {
-		fmt.Printf("Organized project saved to: %s", outputFilename)
+		fmt.Fprintf(out, "Organized project saved to: %s", res.OutputFile)
 	}
-	
-	if duplicatesRemoved > 0 {
-		fmt.Printf(" (removed %d duplicates)", duplicatesRemoved)
+
+	if res.DuplicatesRemoved > 0 {
+		fmt.Fprintf(out, " (removed %d duplicates)", res.DuplicatesRemoved)
 	}
-	
+
 	modeText := " (preserving original structure)"
-	fmt.Println(modeText)
-	
-	return nil
+	if args.organize {
+		modeText = " (with logical organization)"
+	}
+	fmt.Fprintln(out, modeText)
+
+	return res, nil
 }
 
-func getOutputFilename(inputFile string, replaceMode bool) string {
-	if replaceMode {
-		return inputFile + ".tmp." + strconv.FormatInt(time.Now().Unix(), 10)
-	} else {
-		if lastDot := strings.LastIndex(inputFile, "."); lastDot != -1 {
-			name := inputFile[:lastDot]
-			ext := inputFile[lastDot+1:]
-			return name + ".organized." + ext
-		} else {
-			return inputFile + ".organized"
+// processStdio runs fixml as a filter: formatted XML is the only thing
+// written to stdout, every human-readable message goes to stderr
+func processStdio(args Args, file string) (fixml.Result, error) {
+	opts, err := args.options(file)
+	if err != nil {
+		return fixml.Result{}, err
+	}
+	in, err := openInput(file)
+	if err != nil {
+		return fixml.Result{}, err
+	}
+	defer in.Close()
+
+	res, err := fixml.Process(in, os.Stdout, opts)
+	if err != nil {
+		return res, err
+	}
+
+	out := args.messages(os.Stderr)
+	printWarnings(out, args, res)
+	printFixes(out, res)
+
+	if res.DuplicatesRemoved > 0 {
+		modeText := "preserving original structure"
+		if args.organize {
+			modeText = "with logical organization"
 		}
+		fmt.Fprintf(out, "Removed %d duplicates (%s)\n", res.DuplicatesRemoved, modeText)
 	}
+
+	return res, nil
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+// openInput opens a file argument, or stdin for STDIO_FILE
+func openInput(file string) (*os.File, error) {
+	if file == STDIO_FILE {
+		return os.Stdin, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file '%s': %v", file, err)
 	}
-	return b
+	return f, nil
 }
 
-// fastTrimSpace provides optimized whitespace trimming for XML processing
-// Key optimizations:
-// - Fast path for strings that don't need trimming (common case)
-// - Direct byte comparisons using WHITESPACE_THRESHOLD constant
-// - Single allocation when trimming is needed
-// Performance: O(1) for pre-trimmed strings, O(n) worst case
-func fastTrimSpace(s string) string {
-	if len(s) == 0 {
-		return s
-	}
-	
-	// Fast path: check endpoints first to avoid scanning (most strings are pre-trimmed)
-	if s[0] > WHITESPACE_THRESHOLD && s[len(s)-1] > WHITESPACE_THRESHOLD {
-		return s // No allocation needed
-	}
-	
-	// Find first non-whitespace
-	start := 0
-	for start < len(s) && s[start] <= WHITESPACE_THRESHOLD {
-		start++
-	}
-	
-	if start == len(s) {
-		return ""
-	}
-	
-	// Find last non-whitespace
-	end := len(s) - 1
-	for end >= start && s[end] <= WHITESPACE_THRESHOLD {
-		end--
-	}
-	
-	if start == 0 && end == len(s)-1 {
-		return s
-	}
-	
-	return s[start : end+1]
+// checkFile reports whether formatting would change the input without writing anything
+func checkFile(args Args, file string, opts fixml.Options, out io.Writer) (fixml.Result, error) {
+	in, err := openInput(file)
+	if err != nil {
+		return fixml.Result{}, err
+	}
+	defer in.Close()
+
+	res, err := fixml.Check(in, opts)
+	if err != nil {
+		return res, err
+	}
+	reportCheck(args.messages(out), file, res)
+	return res, nil
 }
 
-// isSelfContained determines if XML element is complete on single line
-// Identifies patterns like <tag>content</tag> to avoid incorrect indentation
-// Replaces expensive regex with direct string analysis for better performance
-// Performance: O(n) single pass, much faster than regex alternatives
-func isSelfContained(s string) bool {
-	if len(s) < 7 { // Minimum: <a>b</a>
-		return false
-	}
-	
-	// Extract opening tag name
-	tagNameStart := 1 // Skip '<'
-	tagNameEnd := -1
-	
-	for i := tagNameStart; i < len(s); i++ {
-		if s[i] == ' ' || s[i] == '>' || s[i] == '\t' {
-			tagNameEnd = i
-			break
+// reportCheck prints the --check status line of one file
+func reportCheck(out io.Writer, file string, res fixml.Result) {
+	var details []string
+	if res.DuplicatesRemoved > 0 {
+		details = append(details, fmt.Sprintf("would remove %d duplicates", res.DuplicatesRemoved))
+	}
+	for _, w := range res.Warnings {
+		if w.ID == fixml.WARNING_MISSING_DECLARATION {
+			details = append(details, "missing XML declaration")
 		}
 	}
-	
-	if tagNameEnd == -1 {
-		return false
-	}
-	
-	tagName := s[tagNameStart:tagNameEnd]
-	
-	// Check if line ends with </tagname>
-	if len(s) >= len(tagName)+3 &&
-		s[len(s)-1] == '>' &&
-		len(s) >= len(tagName)+3 &&
-		s[len(s)-len(tagName)-2] == '/' &&
-		s[len(s)-len(tagName)-3] == '<' {
-		
-		closingTagName := s[len(s)-len(tagName)-2:len(s)-1]
-		return len(closingTagName) > 1 && closingTagName[1:] == tagName
-	}
-	
-	return false
+
+	status := "Already formatted"
+	if res.Changed {
+		status = "Would reformat"
+	}
+	fmt.Fprintf(out, "%s: %s", status, file)
+	if len(details) > 0 {
+		fmt.Fprintf(out, " (%s)", strings.Join(details, ", "))
+	}
+	fmt.Fprintln(out)
 }
 
-// computeSemanticHash computes a hash representing the semantic content without allocating normalized strings
-func computeSemanticHash(s string) uint64 {
-	if len(s) == 0 {
-		return 0
+// diffFile prints a unified diff between the cleaned input and the formatted
+// output without writing anything. Combined with --check, the check status is
+// printed first.
+func diffFile(args Args, file string, opts fixml.Options, out io.Writer) (fixml.Result, error) {
+	in, err := openInput(file)
+	if err != nil {
+		return fixml.Result{}, err
 	}
-	
-	hash := fnv.New64a()
-	
-	// Quick check: if no quotes, use simpler hashing
-	if !containsQuotes(s) {
-		// Normalize simple whitespace while hashing
-		prevSpace := false
-		for i := 0; i < len(s); i++ {
-			c := s[i]
-			if c <= WHITESPACE_THRESHOLD {
-				if !prevSpace {
-					hash.Write([]byte{' '}) // Normalize to single space
-					prevSpace = true
-				}
-			} else {
-				hash.Write([]byte{c})
-				prevSpace = false
+	content, err := io.ReadAll(in)
+	in.Close()
+	if err != nil {
+		return fixml.Result{}, fmt.Errorf("could not read file '%s': %v", file, err)
+	}
+
+	res, err := fixml.Check(bytes.NewReader(content), opts)
+	if err != nil {
+		return res, err
+	}
+	if args.check {
+		reportCheck(args.messages(out), file, res)
+	}
+
+	_, err = fixml.Diff(bytes.NewReader(content), out, file, opts, fixml.DiffOptions{Color: args.color})
+	return res, err
+}
+
+// printSummary ends a multi-file run with one line per file and the totals
+func printSummary(args Args, runs []*fileRun) {
+	changedLabel, duplicatesLabel := "changed", "duplicates removed"
+	if args.check || args.diff {
+		changedLabel, duplicatesLabel = "would change", "duplicates to remove"
+	}
+
+	changed, failed, duplicates := 0, 0, 0
+	fmt.Println()
+	fmt.Println("Summary:")
+	for _, r := range runs {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Printf("  %-13s %s: %v\n", "failed", r.file, r.err)
+		case r.res.Changed:
+			changed++
+			duplicates += r.res.DuplicatesRemoved
+			fmt.Printf("  %-13s %s", changedLabel, r.file)
+			if r.res.DuplicatesRemoved > 0 {
+				fmt.Printf(" (%d duplicates)", r.res.DuplicatesRemoved)
 			}
+			printErrorCount(r.res)
+			fmt.Println()
+		default:
+			fmt.Printf("  %-13s %s", "unchanged", r.file)
+			printErrorCount(r.res)
+			fmt.Println()
+		}
+	}
+
+	fmt.Printf("Total: %d files, %d %s, %d unchanged, %d failed, %d %s\n",
+		len(runs), changed, changedLabel, len(runs)-changed-failed, failed, duplicates, duplicatesLabel)
+}
+
+func printErrorCount(res fixml.Result) {
+	if len(res.Errors) > 0 {
+		fmt.Printf(" (%d well-formedness errors)", len(res.Errors))
+	}
+}
+
+// printSyntaxErrors reports well-formedness errors as file:line:col on stderr
+func printSyntaxErrors(file string, res fixml.Result) {
+	for _, e := range res.Errors {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: error: %s\n", file, e.Line, e.Col, e.Message)
+	}
+}
+
+// printWarnings lists the findings grouped by rule, at most
+// MAX_WARNINGS_SHOWN per rule, each group followed by its fix
+func printWarnings(out io.Writer, args Args, res fixml.Result) {
+	if len(res.Warnings) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "⚠️  XML Best Practice Warnings:")
+	for _, group := range groupWarnings(res.Warnings) {
+		// One fix is shown for the group unless the findings need different ones
+		uniform := true
+		for _, w := range group {
+			uniform = uniform && w.Fix == group[0].Fix
 		}
-		return hash.Sum64()
-	}
-	
-	// Handle quotes and attributes with streaming hash
-	inQuotes := false
-	var quoteChar byte
-	prevSpace := false
-	expectingAttrValue := false
-	
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		
-		if !inQuotes && (c == '"' || c == '\'') {
-			inQuotes = true
-			quoteChar = c
-			expectingAttrValue = false
-			// Always hash as double quote for semantic equivalence
-			hash.Write([]byte{'"'})
-			prevSpace = false
-		} else if inQuotes && c == quoteChar {
-			inQuotes = false
-			// Always hash as double quote for semantic equivalence
-			hash.Write([]byte{'"'})
-			prevSpace = false
-		} else if inQuotes {
-			// Inside quotes: preserve all content
-			hash.Write([]byte{c})
-			prevSpace = false
-		} else if c == '=' && !inQuotes {
-			// Found attribute assignment
-			hash.Write([]byte{c})
-			expectingAttrValue = true
-			prevSpace = false
-		} else if expectingAttrValue && c > WHITESPACE_THRESHOLD && c != '>' && c != '/' && c != '"' && c != '\'' {
-			// Unquoted attribute value - normalize by adding quotes
-			hash.Write([]byte{'"'})
-			
-			// Hash the unquoted value (until space, >, or /)
-			j := i
-			for j < len(s) && s[j] > WHITESPACE_THRESHOLD && s[j] != '>' && s[j] != '/' {
-				hash.Write([]byte{s[j]})
-				j++
+		for i, w := range group {
+			if i == MAX_WARNINGS_SHOWN {
+				break
 			}
-			hash.Write([]byte{'"'})
-			i = j - 1 // -1 because the loop will increment
-			expectingAttrValue = false
-			prevSpace = false
-		} else if c <= WHITESPACE_THRESHOLD {
-			expectingAttrValue = false
-			// Outside quotes: normalize whitespace
-			if !prevSpace {
-				hash.Write([]byte{' '})
-				prevSpace = true
+			location := ""
+			if w.Line > 0 {
+				location = fmt.Sprintf("line %d: ", w.Line)
 			}
-		} else {
-			expectingAttrValue = false
-			hash.Write([]byte{c})
-			prevSpace = false
+			fmt.Fprintf(out, "  [%s] %s%s\n", w.Category, location, w.Message)
+			if !uniform {
+				fmt.Fprintf(out, "    Fix: %s\n", w.Fix)
+			}
+		}
+		total := max(res.RuleCounts[group[0].ID], len(group))
+		if more := total - min(len(group), MAX_WARNINGS_SHOWN); more > 0 {
+			fmt.Fprintf(out, "    ... and %d more\n", more)
 		}
+		if uniform {
+			fmt.Fprintf(out, "    Fix: %s\n", group[0].Fix)
+		}
+	}
+	fmt.Fprintln(out)
+
+	if !args.fixWarnings {
+		fmt.Fprintln(out, "Use --fix-warnings flag to automatically apply fixes")
+		fmt.Fprintln(out)
 	}
-	
-	return hash.Sum64()
 }
 
-// normalizeWhitespacePreservingAttributes normalizes structural whitespace while preserving attribute values - optimized
-func normalizeWhitespacePreservingAttributes(s string) string {
-	if len(s) == 0 {
-		return s
-	}
-	
-	// Quick check: if no quotes, use simpler normalization
-	if !containsQuotes(s) {
-		return normalizeSimpleWhitespace(s)
-	}
-	
-	result := builderPool.Get().(*strings.Builder)
-	defer func() {
-		result.Reset()
-		builderPool.Put(result)
-	}()
-	result.Grow(len(s))
-	
-	inQuotes := false
-	var quoteChar byte
-	prevSpace := false
-	expectingAttrValue := false
-	
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		
-		if !inQuotes && (c == '"' || c == '\'') {
-			inQuotes = true
-			quoteChar = c
-			expectingAttrValue = false
-			// Normalize all quotes to double quotes for consistent deduplication
-			result.WriteByte('"')
-			prevSpace = false
-		} else if inQuotes && c == quoteChar {
-			inQuotes = false
-			// Normalize all quotes to double quotes for consistent deduplication
-			result.WriteByte('"')
-			prevSpace = false
-		} else if inQuotes {
-			// Inside quotes: preserve all whitespace
-			result.WriteByte(c)
-			prevSpace = false
-		} else if c == '=' && !inQuotes {
-			// Found attribute assignment, next non-space content might be unquoted value
-			result.WriteByte(c)
-			expectingAttrValue = true
-			prevSpace = false
-		} else if expectingAttrValue && c > WHITESPACE_THRESHOLD && c != '>' && c != '/' && c != '"' && c != '\'' {
-			// Found unquoted attribute value, add quotes around it
-			result.WriteByte('"')
-			
-			// Find the end of the unquoted value (until space, >, or /)
-			j := i
-			for j < len(s) && s[j] > WHITESPACE_THRESHOLD && s[j] != '>' && s[j] != '/' {
-				result.WriteByte(s[j])
-				j++
-			}
-			result.WriteByte('"')
-			i = j - 1 // -1 because the loop will increment
-			expectingAttrValue = false
-			prevSpace = false
-		} else if c <= WHITESPACE_THRESHOLD { // standardized whitespace check
-			expectingAttrValue = false
-			// Outside quotes: normalize whitespace
-			if !prevSpace {
-				result.WriteByte(' ')
-				prevSpace = true
-			}
-		} else {
-			expectingAttrValue = false
-			result.WriteByte(c)
-			prevSpace = false
+// groupWarnings splits warnings by rule, in the order the rules first reported
+func groupWarnings(warnings []fixml.Warning) [][]fixml.Warning {
+	var groups [][]fixml.Warning
+	index := map[string]int{}
+	for _, w := range warnings {
+		i, ok := index[w.ID]
+		if !ok {
+			i = len(groups)
+			index[w.ID] = i
+			groups = append(groups, nil)
 		}
+		groups[i] = append(groups[i], w)
 	}
-	
-	return strings.TrimSpace(result.String())
+	return groups
 }
 
-// Helper function to check if string contains quotes - optimized
-func containsQuotes(s string) bool {
-	return strings.ContainsAny(s, "\"'")
+// printRules lists the registered lint rules for --list-rules
+func printRules(out io.Writer) {
+	for _, r := range fixml.Rules() {
+		info := r.Info()
+		var notes []string
+		if info.Fixed != "" {
+			notes = append(notes, "fixable")
+		}
+		if info.Optional {
+			notes = append(notes, "off by default")
+		}
+		note := ""
+		if len(notes) > 0 {
+			note = " (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Fprintf(out, "%-24s %-8s %s%s\n", info.ID, info.Severity, info.Description, note)
+	}
 }
 
-// Simplified whitespace normalization for strings without quotes
-func normalizeSimpleWhitespace(s string) string {
-	// Preserve original whitespace patterns like Zig - only normalize line endings
-	// This maintains distinct whitespace variants (tabs vs spaces vs multiple spaces)
-	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", " "), "\r", " ")
+func printFixes(out io.Writer, res fixml.Result) {
+	if len(res.Fixes) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "🔧 Applied fixes:")
+	for _, fix := range res.Fixes {
+		fmt.Fprintf(out, "  ✓ %s\n", fix)
+	}
+	fmt.Fprintln(out)
 }
 
 func main() {
-	args := parseArgs()
-	
-	if err := processFile(args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-}
\ No newline at end of file
+	os.Exit(run(parseArgs()))
+}
@@ -0,0 +1,3 @@
+module github.com/n-ae/portfolio/fixml/go
+
+go 1.21
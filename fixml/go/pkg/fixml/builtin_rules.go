@@ -0,0 +1,415 @@
+package fixml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule IDs of the built-in rules, used to enable or disable them in Options.Warnings
+const (
+	WARNING_MISSING_DECLARATION  = "missing-declaration"
+	WARNING_ENCODING_MISMATCH    = "encoding-mismatch"
+	WARNING_UNSUPPORTED_ENCODING = "unsupported-encoding"
+	WARNING_UNQUOTED_ATTRIBUTE   = "unquoted-attribute"
+	WARNING_MIXED_QUOTES         = "mixed-quotes"
+	WARNING_EMPTY_ELEMENT        = "empty-element"
+	WARNING_ATTRIBUTE_SPACING    = "attribute-spacing"
+	WARNING_TAG_WHITESPACE       = "tag-trailing-whitespace"
+	WARNING_MSBUILD_DEPRECATED   = "msbuild-deprecated"
+)
+
+// MSBUILD_2003_NAMESPACE is the namespace of pre-SDK MSBuild project files
+const MSBUILD_2003_NAMESPACE = "http://schemas.microsoft.com/developer/msbuild/2003"
+
+// The built-in rules run in this order: a start tag merged with its end tag
+// by empty-element is then checked for whitespace as an empty-element tag.
+// The style rules that rewrite tags are optional, so --fix-warnings keeps
+// the markup the other FIXML implementations keep unless they are enabled.
+func init() {
+	RegisterRule(missingDeclarationRule{})
+	RegisterRule(encodingMismatchRule{})
+	RegisterRule(unsupportedEncodingRule{})
+	RegisterRule(unquotedAttributeRule{})
+	RegisterRule(mixedQuotesRule{})
+	RegisterRule(emptyElementRule{})
+	RegisterRule(attributeSpacingRule{})
+	RegisterRule(tagWhitespaceRule{})
+	RegisterRule(msbuildDeprecatedRule{})
+}
+
+// tokenRule and documentRule provide the check a rule does not need
+type tokenRule struct{}
+
+func (tokenRule) CheckDocument(Document) []Finding { return nil }
+
+type documentRule struct{}
+
+func (documentRule) Check(Document, Token, *Token) []Finding { return nil }
+
+type missingDeclarationRule struct{ documentRule }
+
+func (missingDeclarationRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_MISSING_DECLARATION,
+		Category:    "XML",
+		Severity:    SeverityWarning,
+		Description: "The document starts without an XML declaration",
+		Fix:         "Add " + strings.TrimSpace(XML_DECLARATION) + " at the top",
+		Fixed:       "Added XML declaration",
+	}
+}
+
+func (missingDeclarationRule) CheckDocument(doc Document) []Finding {
+	if doc.HasXMLDeclaration {
+		return nil
+	}
+	declaration := xmlDeclaration(doc.Encoding)
+	return []Finding{{
+		Message: "Missing XML declaration",
+		Remedy:  "Add " + strings.TrimSpace(declaration) + " at the top",
+		Fix:     &Fix{Raw: declaration},
+	}}
+}
+
+type encodingMismatchRule struct{ tokenRule }
+
+func (encodingMismatchRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_ENCODING_MISMATCH,
+		Category:    "Encoding",
+		Severity:    SeverityWarning,
+		Description: "The XML declaration names a different encoding than the content uses",
+		Fix:         "Declare the encoding the file is saved in",
+		Fixed:       "Corrected encoding declaration",
+	}
+}
+
+func (encodingMismatchRule) Check(doc Document, tok Token, next *Token) []Finding {
+	if tok.Kind != TokenProcInst || tok.Name != "xml" {
+		return nil
+	}
+	start, end := findDeclaredEncoding([]byte(tok.Raw))
+	declared, ok := ENCODING_ALIASES[strings.ToLower(tok.Raw[start:end])]
+	if !ok || declared.sameFamily(doc.Encoding) {
+		return nil
+	}
+	return []Finding{{
+		Message: fmt.Sprintf("Declared encoding %q does not match the %s content", tok.Raw[start:end], doc.Encoding),
+		Fix:     &Fix{Raw: tok.Raw[:start] + doc.Encoding.declarationName() + tok.Raw[end:]},
+	}}
+}
+
+type unsupportedEncodingRule struct{ tokenRule }
+
+func (unsupportedEncodingRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_UNSUPPORTED_ENCODING,
+		Category:    "Encoding",
+		Severity:    SeverityWarning,
+		Description: "The XML declaration names an encoding fixml cannot convert",
+		Fix:         `Convert the file to UTF-8 and declare encoding="utf-8"`,
+	}
+}
+
+func (unsupportedEncodingRule) Check(doc Document, tok Token, next *Token) []Finding {
+	if tok.Kind != TokenProcInst || tok.Name != "xml" {
+		return nil
+	}
+	declared := declaredEncoding([]byte(tok.Raw))
+	if _, ok := ENCODING_ALIASES[strings.ToLower(declared)]; declared == "" || ok {
+		return nil
+	}
+	return []Finding{{Message: fmt.Sprintf("Unsupported encoding %q; content processed as UTF-8", declared)}}
+}
+
+type unquotedAttributeRule struct{ tokenRule }
+
+func (unquotedAttributeRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_UNQUOTED_ATTRIBUTE,
+		Category:    "XML",
+		Severity:    SeverityError,
+		Description: "An attribute value is not quoted",
+		Fix:         "Quote attribute values",
+		Fixed:       "Quoted attribute values",
+	}
+}
+
+func (unquotedAttributeRule) Check(doc Document, tok Token, next *Token) []Finding {
+	if tok.Kind != TokenStartTag && tok.Kind != TokenEmptyTag {
+		return nil
+	}
+	attrs := tok.attributes()
+	var findings []Finding
+	var fixed strings.Builder
+	last := 0
+	for _, a := range attrs {
+		if a.eq < 0 || a.quote != 0 || a.valueEnd == a.valueStart {
+			continue
+		}
+		value := tok.Raw[a.valueStart:a.valueEnd]
+		quote := `"`
+		if strings.Contains(value, `"`) {
+			quote = "'"
+		}
+		fixed.WriteString(tok.Raw[last:a.valueStart])
+		fixed.WriteString(quote + value + quote)
+		last = a.valueEnd
+
+		line, col := advance(tok.Line, tok.Col, tok.Raw[:a.valueStart])
+		findings = append(findings, Finding{Message: fmt.Sprintf("Unquoted value of attribute %q on <%s>", a.name, tok.Name), Line: line, Col: col})
+	}
+	return withFix(findings, fixed, tok.Raw[last:])
+}
+
+type mixedQuotesRule struct{ tokenRule }
+
+func (mixedQuotesRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_MIXED_QUOTES,
+		Category:    "Style",
+		Severity:    SeverityInfo,
+		Description: "Attributes of one tag use both single and double quotes",
+		Fix:         "Use one quote style within a tag",
+	}
+}
+
+func (mixedQuotesRule) Check(doc Document, tok Token, next *Token) []Finding {
+	if tok.Kind != TokenStartTag && tok.Kind != TokenEmptyTag {
+		return nil
+	}
+	var seen byte
+	for _, a := range tok.attributes() {
+		if a.quote == 0 {
+			continue
+		}
+		if seen != 0 && a.quote != seen {
+			return []Finding{{Message: fmt.Sprintf("Attributes of <%s> mix single and double quotes", tok.Name)}}
+		}
+		seen = a.quote
+	}
+	return nil
+}
+
+type emptyElementRule struct{ tokenRule }
+
+func (emptyElementRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_EMPTY_ELEMENT,
+		Category:    "Style",
+		Severity:    SeverityWarning,
+		Description: "An element without content is written with a start and an end tag",
+		Fix:         "Write empty elements as <name/>",
+		Fixed:       "Collapsed empty elements",
+		Optional:    true,
+	}
+}
+
+func (emptyElementRule) Check(doc Document, tok Token, next *Token) []Finding {
+	// A childless root element is left as written
+	if tok.Kind != TokenStartTag || tok.Depth == 0 || next == nil || next.Kind != TokenEndTag || next.Name != tok.Name {
+		return nil
+	}
+	return []Finding{{
+		Message: fmt.Sprintf("Empty element <%s> written with an end tag", tok.Name),
+		Fix:     &Fix{Raw: tok.Raw[:len(tok.Raw)-1] + "/>", MergeNext: true},
+	}}
+}
+
+type attributeSpacingRule struct{ tokenRule }
+
+func (attributeSpacingRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_ATTRIBUTE_SPACING,
+		Category:    "Style",
+		Severity:    SeverityWarning,
+		Description: "Whitespace around the '=' of an attribute",
+		Fix:         `Write attributes as name="value"`,
+		Fixed:       "Removed whitespace around '=' in attributes",
+		Optional:    true,
+	}
+}
+
+func (attributeSpacingRule) Check(doc Document, tok Token, next *Token) []Finding {
+	if tok.Kind != TokenStartTag && tok.Kind != TokenEmptyTag {
+		return nil
+	}
+	var findings []Finding
+	var fixed strings.Builder
+	last := 0
+	for _, a := range tok.attributes() {
+		// Whitespace spanning lines is left alone so line numbers stay intact
+		if a.eq < 0 || (a.nameEnd == a.eq && a.eq+1 == a.valueStart) || strings.ContainsRune(tok.Raw[a.nameEnd:a.valueStart], '\n') {
+			continue
+		}
+		fixed.WriteString(tok.Raw[last:a.nameEnd])
+		fixed.WriteByte('=')
+		last = a.valueStart
+
+		line, col := advance(tok.Line, tok.Col, tok.Raw[:a.nameEnd])
+		findings = append(findings, Finding{Message: fmt.Sprintf("Whitespace around '=' of attribute %q on <%s>", a.name, tok.Name), Line: line, Col: col})
+	}
+	return withFix(findings, fixed, tok.Raw[last:])
+}
+
+type tagWhitespaceRule struct{ tokenRule }
+
+func (tagWhitespaceRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_TAG_WHITESPACE,
+		Category:    "Style",
+		Severity:    SeverityWarning,
+		Description: "Whitespace before the '>' or '/>' that ends a tag",
+		Fix:         "End tags directly after the name or last attribute",
+		Fixed:       "Removed trailing whitespace inside tags",
+		Optional:    true,
+	}
+}
+
+func (tagWhitespaceRule) Check(doc Document, tok Token, next *Token) []Finding {
+	end := len(tok.Raw) - 1 // The '>'
+	switch tok.Kind {
+	case TokenEmptyTag:
+		end--
+	case TokenStartTag, TokenEndTag:
+	default:
+		return nil
+	}
+
+	// Only spaces and tabs after content on the same line; a '>' on a line
+	// of its own is left to the indentation
+	start := end
+	for start > 0 && (tok.Raw[start-1] == ' ' || tok.Raw[start-1] == '\t') {
+		start--
+	}
+	if start == end || tok.Raw[start-1] == '\n' {
+		return nil
+	}
+
+	line, col := advance(tok.Line, tok.Col, tok.Raw[:start])
+	return []Finding{{
+		Message: fmt.Sprintf("Whitespace before '%s' of %s", tok.Raw[end:], tagLabel(tok)),
+		Line:    line,
+		Col:     col,
+		Fix:     &Fix{Raw: tok.Raw[:start] + tok.Raw[end:]},
+	}}
+}
+
+type msbuildDeprecatedRule struct{ tokenRule }
+
+func (msbuildDeprecatedRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_MSBUILD_DEPRECATED,
+		Category:    "MSBuild",
+		Severity:    SeverityInfo,
+		Description: "The project root uses attributes that SDK-style MSBuild ignores",
+		Fix:         "Remove ToolsVersion, and the 2003 namespace from SDK-style projects",
+	}
+}
+
+func (msbuildDeprecatedRule) Check(doc Document, tok Token, next *Token) []Finding {
+	if tok.Kind != TokenStartTag && tok.Kind != TokenEmptyTag || tok.Name != "Project" || tok.Depth != 0 {
+		return nil
+	}
+	attrs := tok.attributes()
+	sdk := false
+	for _, a := range attrs {
+		sdk = sdk || a.name == "Sdk"
+	}
+
+	var findings []Finding
+	for _, a := range attrs {
+		line, col := advance(tok.Line, tok.Col, tok.Raw[:a.nameEnd-len(a.name)])
+		switch {
+		case a.name == "ToolsVersion":
+			findings = append(findings, Finding{Message: "ToolsVersion is ignored since MSBuild 15", Line: line, Col: col})
+		case a.name == "xmlns" && sdk && a.value(tok.Raw) == MSBUILD_2003_NAMESPACE:
+			findings = append(findings, Finding{Message: "The MSBuild 2003 namespace is not needed in SDK-style projects", Line: line, Col: col})
+		}
+	}
+	return findings
+}
+
+// tagLabel names a tag in messages, e.g. <Project> or </Project>
+func tagLabel(tok Token) string {
+	if tok.Kind == TokenEndTag {
+		return "</" + tok.Name + ">"
+	}
+	return "<" + tok.Name + ">"
+}
+
+// withFix attaches the corrected token text to every finding: the fixed
+// prefix built so far followed by the untouched rest
+func withFix(findings []Finding, fixed strings.Builder, rest string) []Finding {
+	if len(findings) == 0 {
+		return nil
+	}
+	fix := &Fix{Raw: fixed.String() + rest}
+	for i := range findings {
+		findings[i].Fix = fix
+	}
+	return findings
+}
+
+// tagAttribute locates one attribute in the text of a start or empty tag
+type tagAttribute struct {
+	name       string
+	nameEnd    int  // Offset after the name
+	eq         int  // Offset of the '=', or -1 for an attribute without value
+	valueStart int  // Offset of the opening quote, or of an unquoted value
+	valueEnd   int  // Offset after the closing quote or the unquoted value
+	quote      byte // '"' or '\'', 0 when unquoted
+}
+
+// value returns the attribute value without quotes
+func (a tagAttribute) value(raw string) string {
+	if a.quote != 0 {
+		return raw[a.valueStart+1 : a.valueEnd-1]
+	}
+	return raw[a.valueStart:a.valueEnd]
+}
+
+// scanAttributes lists the attributes of a tag. An unterminated quoted
+// value ends the list.
+func scanAttributes(raw string) []tagAttribute {
+	var attrs []tagAttribute
+	if n := strings.Count(raw, "="); n > 0 {
+		attrs = make([]tagAttribute, 0, n)
+	}
+	i := skipName(raw, 1)
+	for {
+		i = skipSpace(raw, i)
+		if i >= len(raw) || raw[i] == '>' || raw[i] == '/' {
+			return attrs
+		}
+		start := i
+		i = skipName(raw, i)
+		if i == start {
+			i++ // Not a name; skip the character
+			continue
+		}
+
+		a := tagAttribute{name: raw[start:i], nameEnd: i, eq: -1}
+		j := skipSpace(raw, i)
+		if j < len(raw) && raw[j] == '=' {
+			a.eq = j
+			j = skipSpace(raw, j+1)
+			a.valueStart = j
+			if j < len(raw) && (raw[j] == '"' || raw[j] == '\'') {
+				end := strings.IndexByte(raw[j+1:], raw[j])
+				if end < 0 {
+					return attrs
+				}
+				a.quote = raw[j]
+				a.valueEnd = j + end + 2
+			} else {
+				for j < len(raw) && raw[j] > WHITESPACE_THRESHOLD && raw[j] != '>' && !strings.HasPrefix(raw[j:], "/>") {
+					j++
+				}
+				a.valueEnd = j
+			}
+			i = a.valueEnd
+		}
+		attrs = append(attrs, a)
+	}
+}
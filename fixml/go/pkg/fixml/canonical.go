@@ -0,0 +1,355 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// CanonicalMode selects the W3C Canonical XML variant written instead of
+// the formatted document.
+type CanonicalMode int
+
+const (
+	// CanonicalNone writes the formatted document.
+	CanonicalNone CanonicalMode = iota
+	// CanonicalC14N writes Canonical XML 1.0 (without comments).
+	CanonicalC14N
+	// CanonicalExclusive writes Exclusive XML Canonicalization 1.0 (without
+	// comments): namespace declarations move to the elements that use them.
+	CanonicalExclusive
+)
+
+func (m CanonicalMode) String() string {
+	switch m {
+	case CanonicalC14N:
+		return "c14n"
+	case CanonicalExclusive:
+		return "exc-c14n"
+	}
+	return "none"
+}
+
+// Canonicalize writes the canonical form of the XML read from r to w: UTF-8
+// with LF line breaks, no XML declaration, DOCTYPE or comments, attributes
+// and namespace declarations sorted and their values normalized, character
+// references resolved, and empty elements written as start and end tag.
+// Whitespace between elements is kept, as Canonical XML requires. Nothing
+// is written unless the input is well-formed. Entity references other than
+// the predefined ones cannot be resolved without the DTD and are kept.
+func Canonicalize(r io.Reader, w io.Writer, mode CanonicalMode) error {
+	if mode == CanonicalNone {
+		mode = CanonicalC14N
+	}
+	v := &validator{}
+	c := &canonicalizer{mode: mode}
+	tokens := newTokenizer(bufio.NewReaderSize(NewCleanReader(r), IO_CHUNK_SIZE))
+	for {
+		tok, err := tokens.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read input: %v", err)
+		}
+		v.token(tok)
+		c.token(tok)
+	}
+	if errs := v.finish(); len(errs) > 0 {
+		return fmt.Errorf("input is not well-formed (%d errors; first: %v)", len(errs), errs[0])
+	}
+	if c.depth == 0 && !c.afterRoot {
+		return fmt.Errorf("input has no root element")
+	}
+	if _, err := w.Write(c.out.Bytes()); err != nil {
+		return fmt.Errorf("could not write output: %v", err)
+	}
+	return nil
+}
+
+// canonicalScope is the namespace context of an open element
+type canonicalScope struct {
+	name     string
+	inScope  map[string]string // URI by prefix, "" for the default namespace; shared until changed
+	rendered map[string]string // Declarations written by the element and its ancestors
+}
+
+// canonicalizer writes the canonical form of a token stream
+type canonicalizer struct {
+	mode      CanonicalMode
+	out       bytes.Buffer
+	stack     []canonicalScope
+	depth     int
+	afterRoot bool // The document element has been closed
+}
+
+// canonicalAttribute is an attribute or namespace declaration to be written
+type canonicalAttribute struct {
+	name  string // As written
+	space string // Namespace URI, or the prefix for declarations
+	local string
+	value string // Normalized and with references resolved
+}
+
+func (c *canonicalizer) token(tok token) {
+	switch tok.kind {
+	case TokenStartTag, TokenEmptyTag:
+		c.startTag(tok)
+		if tok.kind == TokenEmptyTag {
+			c.endTag()
+		}
+	case TokenEndTag:
+		c.endTag()
+	case TokenText:
+		if c.depth > 0 {
+			writeCanonicalText(&c.out, tok.raw, false)
+		}
+	case TokenCData:
+		if c.depth > 0 {
+			content := strings.TrimSuffix(strings.TrimPrefix(tok.raw, "<![CDATA["), "]]>")
+			for _, r := range content {
+				writeCanonicalRune(&c.out, r, false)
+			}
+		}
+	case TokenProcInst:
+		if tok.name == "xml" {
+			return // The declaration is not part of the canonical form
+		}
+		// Outside the document element, processing instructions are
+		// separated from it by a line break
+		if c.depth == 0 && c.afterRoot {
+			c.out.WriteByte('\n')
+		}
+		body := strings.TrimSuffix(strings.TrimPrefix(tok.raw, "<?"), "?>")
+		c.out.WriteString("<?" + tok.name)
+		if data := trimLeftSpace(body[len(tok.name):]); data != "" {
+			c.out.WriteString(" " + data)
+		}
+		c.out.WriteString("?>")
+		if c.depth == 0 && !c.afterRoot {
+			c.out.WriteByte('\n')
+		}
+	}
+	// Comments and the DOCTYPE are left out
+}
+
+func (c *canonicalizer) startTag(tok token) {
+	parent := canonicalScope{inScope: map[string]string{}, rendered: map[string]string{}}
+	if len(c.stack) > 0 {
+		parent = c.stack[len(c.stack)-1]
+	}
+	scope := canonicalScope{name: tok.name, inScope: parent.inScope, rendered: parent.rendered}
+
+	var attrs, declared []canonicalAttribute
+	for _, a := range scanAttributes(tok.raw) {
+		if a.eq < 0 {
+			continue
+		}
+		var value bytes.Buffer
+		writeAttributeValue(&value, a.value(tok.raw))
+		attr := canonicalAttribute{name: a.name, value: value.String()}
+		switch {
+		case a.name == "xmlns":
+			declared = append(declared, attr)
+		case strings.HasPrefix(a.name, "xmlns:"):
+			attr.space = a.name[len("xmlns:"):]
+			declared = append(declared, attr)
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+	if len(declared) > 0 {
+		scope.inScope = make(map[string]string, len(parent.inScope)+len(declared))
+		for prefix, uri := range parent.inScope {
+			scope.inScope[prefix] = uri
+		}
+		for _, d := range declared {
+			scope.inScope[d.space] = d.value
+		}
+	}
+
+	// Namespace declarations to write: inclusive canonicalization writes
+	// every declaration that changes what the parent had in scope; the
+	// exclusive form only those of prefixes the element and its attributes
+	// use, where an ancestor has not written them already
+	var prefixes []string
+	if c.mode == CanonicalExclusive {
+		prefixes = append(prefixes, namePrefix(tok.name))
+		for _, a := range attrs {
+			if p := namePrefix(a.name); p != "" {
+				prefixes = append(prefixes, p)
+			}
+		}
+	} else {
+		for _, d := range declared {
+			prefixes = append(prefixes, d.space)
+		}
+	}
+	var namespaces []canonicalAttribute
+	seen := make(map[string]bool, len(prefixes))
+	for _, prefix := range prefixes {
+		uri, bound := scope.inScope[prefix]
+		if seen[prefix] || prefix == "xml" || !bound && prefix != "" {
+			continue
+		}
+		seen[prefix] = true
+		if written, ok := scope.rendered[prefix]; written == uri && (ok || uri == "") {
+			continue
+		}
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		namespaces = append(namespaces, canonicalAttribute{name: name, space: prefix, value: uri})
+	}
+	if len(namespaces) > 0 {
+		rendered := make(map[string]string, len(scope.rendered)+len(namespaces))
+		for prefix, uri := range scope.rendered {
+			rendered[prefix] = uri
+		}
+		for _, ns := range namespaces {
+			rendered[ns.space] = ns.value
+		}
+		scope.rendered = rendered
+	}
+
+	// Declarations sort by prefix, the default one first; attributes by
+	// namespace URI and local name, unqualified ones first
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].space < namespaces[j].space })
+	for i := range attrs {
+		a := &attrs[i]
+		a.local = a.name
+		if prefix := namePrefix(a.name); prefix != "" {
+			a.local = a.name[len(prefix)+1:]
+			a.space = scope.inScope[prefix]
+			if prefix == "xml" {
+				a.space = XML_NAMESPACE
+			}
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	c.out.WriteString("<" + tok.name)
+	for _, a := range append(namespaces, attrs...) {
+		c.out.WriteString(" " + a.name + `="`)
+		for _, r := range a.value {
+			writeCanonicalRune(&c.out, r, true)
+		}
+		c.out.WriteByte('"')
+	}
+	c.out.WriteByte('>')
+	c.stack = append(c.stack, scope)
+	c.depth++
+}
+
+func (c *canonicalizer) endTag() {
+	if len(c.stack) == 0 {
+		return
+	}
+	top := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	c.depth--
+	c.out.WriteString("</" + top.name + ">")
+	if c.depth == 0 {
+		c.afterRoot = true
+	}
+}
+
+// namePrefix returns the prefix of a qualified name, or ""
+func namePrefix(name string) string {
+	if i := strings.IndexByte(name, ':'); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// writeAttributeValue applies attribute-value normalization: literal tabs
+// and line breaks become spaces, then references are resolved. Unknown
+// entity references are kept as written.
+func writeAttributeValue(b *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\t', '\n', '\r':
+			b.WriteByte(' ')
+		case '&':
+			n, r, ok := canonicalReference(s[i:])
+			if ok {
+				b.WriteRune(r)
+			} else {
+				b.WriteString(s[i : i+n])
+			}
+			i += n - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// writeCanonicalText writes character data with references resolved and
+// the characters Canonical XML requires escaped
+func writeCanonicalText(b *bytes.Buffer, s string, attr bool) {
+	for i := 0; i < len(s); {
+		if s[i] == '&' {
+			n, r, ok := canonicalReference(s[i:])
+			if ok {
+				writeCanonicalRune(b, r, attr)
+			} else {
+				b.WriteString(s[i : i+n])
+			}
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		writeCanonicalRune(b, r, attr)
+		i += size
+	}
+}
+
+// canonicalReference resolves the reference at the start of s and returns
+// its length. ok is false for an entity reference that cannot be resolved,
+// which is kept, and for a bare '&' (length 1), which is escaped.
+func canonicalReference(s string) (int, rune, bool) {
+	end := strings.IndexByte(s, ';')
+	if end < 2 {
+		return 1, '&', true
+	}
+	if r, ok := decodeReference(s[1:end]); ok {
+		return end + 1, r, true
+	}
+	if isXMLName(s[1:end]) {
+		return end + 1, 0, false
+	}
+	return 1, '&', true
+}
+
+// writeCanonicalRune escapes r as Canonical XML does in text or, with attr,
+// in attribute values
+func writeCanonicalRune(b *bytes.Buffer, r rune, attr bool) {
+	switch {
+	case r == '&':
+		b.WriteString("&amp;")
+	case r == '<':
+		b.WriteString("&lt;")
+	case r == '>' && !attr:
+		b.WriteString("&gt;")
+	case r == '"' && attr:
+		b.WriteString("&quot;")
+	case r == '\t' && attr:
+		b.WriteString("&#x9;")
+	case r == '\n' && attr:
+		b.WriteString("&#xA;")
+	case r == '\r':
+		b.WriteString("&#xD;")
+	default:
+		b.WriteRune(r)
+	}
+}
@@ -0,0 +1,243 @@
+package fixml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The examples of section 3 of the Canonical XML 1.0 recommendation,
+// https://www.w3.org/TR/xml-c14n/. Without the DTD, attributes keep the
+// CDATA normalization and the entity references of 3.5 are kept; 3.7 and
+// 3.8 canonicalize XPath node-sets, which fixml does not select.
+func TestCanonicalizeSpecExamples(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			"3.1 PIs, comments, and outside of document element",
+			`<?xml version="1.0"?>
+
+<?xml-stylesheet   href="doc.xsl"
+   type="text/xsl"   ?>
+
+<!DOCTYPE doc SYSTEM "doc.dtd">
+
+<doc>Hello, world!<!-- Comment 1 --></doc>
+
+<?pi-without-data     ?>
+
+<!-- Comment 2 -->
+
+<!-- Comment 3 -->
+`,
+			`<?xml-stylesheet href="doc.xsl"
+   type="text/xsl"   ?>
+<doc>Hello, world!</doc>
+<?pi-without-data?>`,
+		},
+		{
+			"3.2 whitespace in document content",
+			`<doc>
+   <clean>   </clean>
+   <dirty>   A   B   </dirty>
+   <mixed>
+      A
+      <clean>   </clean>
+      B
+      <dirty>   A   B   </dirty>
+      C
+   </mixed>
+</doc>
+`,
+			`<doc>
+   <clean>   </clean>
+   <dirty>   A   B   </dirty>
+   <mixed>
+      A
+      <clean>   </clean>
+      B
+      <dirty>   A   B   </dirty>
+      C
+   </mixed>
+</doc>`,
+		},
+		{
+			"3.3 start and end tags",
+			`<!DOCTYPE doc [<!ATTLIST e9 attr CDATA "default">]>
+<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e4   name="elem4"   id="elem4"   ></e4>
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="" xmlns:a="http://www.w3.org">
+            <e9 xmlns="" xmlns:a="http://www.ietf.org"/>
+         </e8>
+      </e7>
+   </e6>
+</doc>
+`,
+			`<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e4 id="elem4" name="elem4"></e4>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6 xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="">
+            <e9 xmlns:a="http://www.ietf.org"></e9>
+         </e8>
+      </e7>
+   </e6>
+</doc>`,
+		},
+		{
+			"3.4 character modifications and character references",
+			`<!DOCTYPE doc [
+<!ATTLIST normId id ID #IMPLIED>
+<!ATTLIST normNames attr NMTOKENS #IMPLIED>
+]>
+<doc>
+   <text>First line&#x0d;&#10;Second line</text>
+   <value>&#x32;</value>
+   <compute><![CDATA[value>"0" && value<"10" ?"valid":"error"]]></compute>
+   <compute expr='value>"0" &amp;&amp; value&lt;"10" ?"valid":"error"'>valid</compute>
+   <norm attr=' &apos;   &#x20;&#13;&#xa;&#9;   &apos; '/>
+   <normNames attr='   A   &#x20;&#13;&#xa;&#9;   B   '/>
+</doc>
+`,
+			`<doc>
+   <text>First line&#xD;
+Second line</text>
+   <value>2</value>
+   <compute>value&gt;"0" &amp;&amp; value&lt;"10" ?"valid":"error"</compute>
+   <compute expr="value>&quot;0&quot; &amp;&amp; value&lt;&quot;10&quot; ?&quot;valid&quot;:&quot;error&quot;">valid</compute>
+   <norm attr=" '    &#xD;&#xA;&#x9;   ' "></norm>
+   <normNames attr="   A    &#xD;&#xA;&#x9;   B   "></normNames>
+</doc>`,
+		},
+		{
+			"3.5 entity references",
+			`<!DOCTYPE doc [
+<!ATTLIST doc attrExtEnt ENTITY #IMPLIED>
+<!ENTITY ent1 "Hello">
+<!ENTITY ent2 SYSTEM "world.txt">
+<!ENTITY entExt SYSTEM "earth.gif" NDATA gif>
+<!NOTATION gif SYSTEM "viewgif.exe">
+]>
+<doc attrExtEnt="entExt">
+   &ent1;, &ent2;!
+</doc>
+
+<!-- Let world.txt contain "world" (excluding the quotes) -->
+`,
+			`<doc attrExtEnt="entExt">
+   &ent1;, &ent2;!
+</doc>`,
+		},
+		{
+			"3.6 UTF-8 encoding",
+			"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<doc>&#169;\xA9</doc>\n",
+			"<doc>©©</doc>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Canonicalize(strings.NewReader(tt.input), &out, CanonicalC14N); err != nil {
+				t.Fatalf("Canonicalize failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Canonicalize =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeNamespaces(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		inclusive string
+		exclusive string
+	}{
+		{
+			"attributes sort by namespace URI, then local name",
+			`<r xmlns:z="http://a" xmlns:a="http://b" a:x="1" z:y="2" b="3" z:a="4"/>`,
+			`<r xmlns:a="http://b" xmlns:z="http://a" b="3" z:a="4" z:y="2" a:x="1"></r>`,
+			`<r xmlns:a="http://b" xmlns:z="http://a" b="3" z:a="4" z:y="2" a:x="1"></r>`,
+		},
+		{
+			"declarations already in scope are pruned",
+			`<r xmlns:p="u" xmlns="d"><p:a xmlns:p="u"><b xmlns="d"/></p:a></r>`,
+			`<r xmlns="d" xmlns:p="u"><p:a><b></b></p:a></r>`,
+			`<r xmlns="d"><p:a xmlns:p="u"><b></b></p:a></r>`,
+		},
+		{
+			"only visibly used prefixes in exclusive mode",
+			`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff></n3:stuff></n1:elem2></n0:local>`,
+			`<n0:local xmlns:n0="foo:bar"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2></n0:local>`,
+		},
+		{
+			"prefixes used by attributes are visibly used",
+			`<a xmlns:p="u" xmlns:q="v" p:x="1"><b q:y="2"/><c/></a>`,
+			`<a xmlns:p="u" xmlns:q="v" p:x="1"><b q:y="2"></b><c></c></a>`,
+			`<a xmlns:p="u" p:x="1"><b xmlns:q="v" q:y="2"></b><c></c></a>`,
+		},
+		{
+			"an undeclared default namespace is written empty only where it changes",
+			`<a xmlns="u"><b xmlns=""><c/></b></a>`,
+			`<a xmlns="u"><b xmlns=""><c></c></b></a>`,
+			`<a xmlns="u"><b xmlns=""><c></c></b></a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct {
+				mode CanonicalMode
+				want string
+			}{{CanonicalC14N, tt.inclusive}, {CanonicalExclusive, tt.exclusive}} {
+				var out bytes.Buffer
+				if err := Canonicalize(strings.NewReader(tt.input), &out, c.mode); err != nil {
+					t.Fatalf("Canonicalize(%s) failed: %v", c.mode, err)
+				}
+				if out.String() != c.want {
+					t.Errorf("Canonicalize(%s) =\n%s\nwant\n%s", c.mode, out.String(), c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalizeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"not well-formed", "<a><b></a>", "input is not well-formed"},
+		{"no root element", "<?pi?>\n<!-- only -->\n", "input has no root element"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Canonicalize(strings.NewReader(tt.input), &out, CanonicalC14N)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Canonicalize error = %v, want %q", err, tt.want)
+			}
+			if out.Len() > 0 {
+				t.Errorf("Canonicalize wrote %q despite the error", out.String())
+			}
+		})
+	}
+}
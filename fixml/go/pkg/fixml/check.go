@@ -0,0 +1,110 @@
+package fixml
+
+import (
+	"bytes"
+	"hash/maphash"
+	"io"
+)
+
+// Check runs the same formatting as Process but discards the output.
+// Result.Changed reports whether the output would differ from the input,
+// which makes Check suitable for CI gating.
+func Check(r io.Reader, opts Options) (Result, error) {
+	return Process(r, io.Discard, opts)
+}
+
+// compareWriter matches the input stream against the output stream while
+// both are produced. Only the bytes one side has produced ahead of the other
+// are buffered, and buffering stops at the first difference. Past
+// MAX_COMPARE_BYTES of lead, as when the whole input is read before any
+// output, the rest of each stream is hashed instead.
+type compareWriter struct {
+	ahead       []byte // Bytes of the leading side not yet matched
+	outputAhead bool   // Whether ahead holds output (true) or input bytes
+	changed     bool
+
+	hashing bool // Past MAX_COMPARE_BYTES: the rests are compared by hash
+	rests   [2]compareRest
+}
+
+// compareRest is the hashed rest of one stream
+type compareRest struct {
+	hash maphash.Hash
+	size int64
+}
+
+// differs reports the outcome once both streams are complete
+func (c *compareWriter) differs() bool {
+	if c.hashing && !c.changed {
+		in, out := &c.rests[0], &c.rests[1]
+		return in.size != out.size || in.hash.Sum64() != out.hash.Sum64()
+	}
+	return c.changed || len(c.ahead) > 0
+}
+
+func (c *compareWriter) match(p []byte, fromOutput bool) {
+	if c.changed || len(p) == 0 {
+		return
+	}
+	if c.hashing {
+		c.rest(fromOutput).write(p)
+		return
+	}
+
+	if len(c.ahead) == 0 || c.outputAhead == fromOutput {
+		c.ahead = append(c.ahead, p...)
+		c.outputAhead = fromOutput
+		if len(c.ahead) > MAX_COMPARE_BYTES {
+			// Everything before the lead matched, so the rests start at
+			// the same offset
+			c.hashing = true
+			seed := maphash.MakeSeed()
+			c.rests[0].hash.SetSeed(seed)
+			c.rests[1].hash.SetSeed(seed)
+			c.rest(fromOutput).write(c.ahead)
+			c.ahead = nil
+		}
+		return
+	}
+
+	n := len(p)
+	if n > len(c.ahead) {
+		n = len(c.ahead)
+	}
+	if !bytes.Equal(p[:n], c.ahead[:n]) {
+		c.changed = true
+		c.ahead = nil
+		return
+	}
+
+	c.ahead = c.ahead[n:]
+	if n < len(p) {
+		// This side overtook the other one and is now leading
+		c.ahead = append(c.ahead, p[n:]...)
+		c.outputAhead = fromOutput
+	}
+}
+
+// rest returns the hashed rest of the output or the input stream
+func (c *compareWriter) rest(output bool) *compareRest {
+	if output {
+		return &c.rests[1]
+	}
+	return &c.rests[0]
+}
+
+func (r *compareRest) write(p []byte) {
+	r.hash.Write(p)
+	r.size += int64(len(p))
+}
+
+// compareSide feeds one of the two streams into a compareWriter
+type compareSide struct {
+	c      *compareWriter
+	output bool
+}
+
+func (s compareSide) Write(p []byte) (int, error) {
+	s.c.match(p, s.output)
+	return len(p), nil
+}
@@ -0,0 +1,132 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// cleanReader performs content cleaning incrementally: the input encoding is
+// detected and converted to UTF-8, the BOM is dropped and CRLF / lone CR
+// line endings are normalized to LF.
+// Input is pulled in IO_CHUNK_SIZE chunks, so memory use does not depend on
+// the document size. A CR at the end of one chunk is remembered so that a
+// LF starting the next chunk is recognized as the second half of a CRLF.
+type cleanReader struct {
+	raw       *bufio.Reader
+	src       io.Reader // raw, or a decodingReader on top of it
+	started   bool
+	encoding  Encoding
+	bom       bool   // The input started with a byte order mark
+	declared  string // Encoding named in the XML declaration, if any
+	pendingCR bool
+
+	// Line endings seen so far, for EOLAuto and FinalNewlineKeep
+	newlines   int  // Line breaks of any kind
+	crlf       int  // Of which CRLF
+	endNewline bool // The content returned so far ends with a line break
+}
+
+// NewCleanReader returns a reader yielding the content of r as UTF-8,
+// without a BOM and with all line endings normalized to LF.
+// UTF-16 is recognized by its BOM or first bytes; ISO-8859-1 and
+// windows-1252 by the XML declaration.
+func NewCleanReader(r io.Reader) io.Reader {
+	return newCleanReader(r)
+}
+
+func newCleanReader(r io.Reader) *cleanReader {
+	return &cleanReader{raw: bufio.NewReaderSize(r, IO_CHUNK_SIZE)}
+}
+
+// detect determines the input encoding on first use; Peek waits until the
+// start of the input has arrived
+func (c *cleanReader) detect() {
+	if c.started {
+		return
+	}
+	c.started = true
+
+	enc, bom, declared := detectEncoding(c.raw)
+	c.raw.Discard(bom)
+	c.encoding, c.bom, c.declared = enc, bom > 0, declared
+	c.src = c.raw
+	if enc != EncodingUTF8 {
+		c.src = newDecodingReader(c.raw, enc)
+	}
+}
+
+// lineEnding returns the line ending used by most line breaks read so far;
+// ties, and input without line breaks, select LF
+func (c *cleanReader) lineEnding() string {
+	if c.crlf*2 > c.newlines {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func (c *cleanReader) Read(p []byte) (int, error) {
+	c.detect()
+
+	for {
+		n, err := c.src.Read(p)
+
+		// Single-pass line ending normalization, compacting in place:
+		// the output never grows, so w can never overtake i. Chunks without
+		// a CR are returned as they are.
+		w := n
+		if c.pendingCR || bytes.IndexByte(p[:n], '\r') >= 0 {
+			w = c.normalize(p[:n])
+		} else {
+			c.newlines += bytes.Count(p[:n], []byte{'\n'})
+		}
+		if w > 0 {
+			c.endNewline = p[w-1] == '\n'
+		}
+
+		// A chunk made only of the LF of a split CRLF yields nothing;
+		// read on instead of returning the (0, nil) io.Reader discourages
+		if w > 0 || err != nil || len(p) == 0 {
+			return w, err
+		}
+	}
+}
+
+// normalize converts the line endings of p in place and returns the length
+// of the result
+func (c *cleanReader) normalize(p []byte) int {
+	w := 0
+	for i := 0; i < len(p); i++ {
+		b := p[i]
+		if b == '\n' && c.pendingCR {
+			c.pendingCR = false
+			c.crlf++
+			continue // Second half of a CRLF already emitted as LF
+		}
+		c.pendingCR = b == '\r'
+		if c.pendingCR {
+			b = '\n'
+		}
+		if b == '\n' {
+			c.newlines++
+		}
+		p[w] = b
+		w++
+	}
+	return w
+}
+
+// sizeHint reports the input size when it can be known without reading,
+// or 0 otherwise. It is used to pre-size the deduplication map.
+func sizeHint(r io.Reader) int {
+	switch v := r.(type) {
+	case *os.File:
+		if info, err := v.Stat(); err == nil && info.Mode().IsRegular() {
+			return int(info.Size())
+		}
+	case interface{ Len() int }:
+		return v.Len()
+	}
+	return 0
+}
@@ -0,0 +1,430 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const DIFF_CONTEXT_LINES = 3     // Unchanged lines shown around each change
+const MIN_DIFF_COST_LIMIT = 4096 // Edit cost a diff search may always reach before settling for a good split
+
+// ANSI escape sequences used when DiffOptions.Color is set
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorCyan   = "\x1b[36m"
+	noNewlineAt = "\\ No newline at end of file\n"
+)
+
+// DiffOptions controls how Diff renders changes.
+type DiffOptions struct {
+	Context int  // Unchanged lines around each hunk; 0 selects DIFF_CONTEXT_LINES
+	Color   bool // Wrap headers, removals and additions in ANSI colors
+}
+
+// Diff formats the XML read from r and writes a unified diff between the
+// cleaned input and the formatted output to w. name labels both sides of the
+// diff. Unlike Process, Diff holds the whole document in memory.
+func Diff(r io.Reader, w io.Writer, name string, opts Options, dopts DiffOptions) (Result, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, fmt.Errorf("could not read input: %v", err)
+	}
+
+	cleaned, err := io.ReadAll(NewCleanReader(bytes.NewReader(content)))
+	if err != nil {
+		return Result{}, fmt.Errorf("could not read input: %v", err)
+	}
+
+	// Both sides are compared as UTF-8 with LF line endings
+	opts.KeepEncoding = false
+	opts.EOL = EOLLF
+	var formatted bytes.Buffer
+	res, err := Process(bytes.NewReader(content), &formatted, opts)
+	if err != nil {
+		return res, err
+	}
+
+	output := bufio.NewWriterSize(w, IO_CHUNK_SIZE)
+	writeUnifiedDiff(output, name, splitLines(string(cleaned)), splitLines(formatted.String()), dopts)
+	if err := output.Flush(); err != nil {
+		return res, fmt.Errorf("could not write diff: %v", err)
+	}
+	return res, nil
+}
+
+// splitLines splits s after every newline; a final line without a newline is kept as is
+func splitLines(s string) []string {
+	lines := make([]string, 0, len(s)/ESTIMATED_LINE_LENGTH+1)
+	for len(s) > 0 {
+		end := strings.IndexByte(s, '\n') + 1
+		if end == 0 {
+			end = len(s)
+		}
+		lines = append(lines, s[:end])
+		s = s[end:]
+	}
+	return lines
+}
+
+// diffOp is one line of an edit script
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+func writeUnifiedDiff(w *bufio.Writer, name string, a, b []string, dopts DiffOptions) {
+	ops := diffLines(a, b)
+
+	context := dopts.Context
+	if context <= 0 {
+		context = DIFF_CONTEXT_LINES
+	}
+
+	// Locate hunks: runs of changes whose surrounding context overlaps
+	headerWritten := false
+	aLine, bLine := 0, 0 // Lines of a and b consumed before ops[i]
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops) && j-end <= 2*context+1; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if !headerWritten {
+			writeColored(w, dopts.Color, colorBold, "--- "+name+"\t(original)\n")
+			writeColored(w, dopts.Color, colorBold, "+++ "+name+"\t(formatted)\n")
+			headerWritten = true
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		writeColored(w, dopts.Color, colorCyan, fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount)))
+
+		for _, op := range ops[start:stop] {
+			color := ""
+			switch op.kind {
+			case '-':
+				color = colorRed
+			case '+':
+				color = colorGreen
+			}
+			writeColored(w, dopts.Color && color != "", color, string(op.kind)+op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				w.WriteString("\n" + noNewlineAt)
+			}
+		}
+
+		for _, op := range ops[i:stop] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		i = stop
+	}
+}
+
+// hunkRange renders the 1-based start,count pair of a hunk header
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeColored(w *bufio.Writer, enabled bool, color, text string) {
+	if !enabled {
+		w.WriteString(text)
+		return
+	}
+	// Keep the newline outside the escape sequence so pagers stay aligned
+	body := strings.TrimSuffix(text, "\n")
+	w.WriteString(color)
+	w.WriteString(body)
+	w.WriteString(colorReset)
+	if len(body) < len(text) {
+		w.WriteByte('\n')
+	}
+}
+
+// diffLines computes an edit script between a and b using Myers'
+// linear-space divide-and-conquer algorithm: O((N+M)D) time, O(N+M) space.
+// As in GNU diff, a search that grows too expensive settles for a good
+// split instead of an optimal one, so heavily changed inputs take close to
+// linear time; the script is then not always minimal.
+// Deletions are listed before insertions within each changed region.
+func diffLines(a, b []string) []diffOp {
+	// Intern lines so the inner loops compare integers instead of strings
+	ids := make(map[string]int, len(a)+len(b))
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	aIDs, bIDs := intern(a), intern(b)
+
+	// Lines without any counterpart on the other side can never match, so
+	// they are decided up front and left out of the search (as GNU diff
+	// does). Reindented documents consist mostly of such lines.
+	inA := make([]bool, len(ids))
+	inB := make([]bool, len(ids))
+	for _, id := range aIDs {
+		inA[id] = true
+	}
+	for _, id := range bIDs {
+		inB[id] = true
+	}
+	deleted := make([]bool, len(a))
+	added := make([]bool, len(b))
+	aKept, aIndex := filterMatchable(aIDs, inB, deleted)
+	bKept, bIndex := filterMatchable(bIDs, inA, added)
+
+	d := &differ{
+		a:       aKept,
+		b:       bKept,
+		deleted: make([]bool, len(aKept)),
+		added:   make([]bool, len(bKept)),
+	}
+	size := len(aKept) + len(bKept) + 3
+	d.vf = make([]int, size)
+	d.vb = make([]int, size)
+	// Roughly the square root of the diagonals, as GNU diff computes it
+	d.tooExpensive = 1
+	for diags := size; diags != 0; diags >>= 2 {
+		d.tooExpensive <<= 1
+	}
+	d.tooExpensive = max(d.tooExpensive, MIN_DIFF_COST_LIMIT)
+	d.compare(0, len(aKept), 0, len(bKept))
+	for i, del := range d.deleted {
+		deleted[aIndex[i]] = del
+	}
+	for j, add := range d.added {
+		added[bIndex[j]] = add
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && deleted[i]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		case j < len(b) && added[j]:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		default:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+// filterMatchable returns the ids that also occur on the other side together
+// with their original positions; every other line is marked in unmatched
+func filterMatchable(ids []int, other []bool, unmatched []bool) ([]int, []int) {
+	kept := make([]int, 0, len(ids))
+	index := make([]int, 0, len(ids))
+	for i, id := range ids {
+		if other[id] {
+			kept = append(kept, id)
+			index = append(index, i)
+		} else {
+			unmatched[i] = true
+		}
+	}
+	return kept, index
+}
+
+type differ struct {
+	a, b           []int
+	deleted, added []bool
+	vf, vb         []int // Furthest reaching x per diagonal, forward and backward
+	tooExpensive   int   // Edit cost after which split gives up on an optimal path
+}
+
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// Common prefix and suffix never take part in the edit script
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.added[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		x, y := d.split(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// split returns a point on an optimal path through the edit graph of
+// a[aLo:aHi] and b[bLo:bHi] where roughly half of the edits lie on each side.
+// Once the cost reaches tooExpensive it returns the furthest point either
+// search has reached instead.
+// Both searches index diagonals k = x - y in absolute coordinates; the
+// slots just outside the searched range hold sentinels so no bounds checks
+// are needed. Callers must have stripped the common prefix and suffix.
+func (d *differ) split(aLo, aHi, bLo, bHi int) (int, int) {
+	kMin, kMax := aLo-bHi, aHi-bLo
+	fMid, bMid := aLo-bLo, aHi-bHi
+	odd := (fMid-bMid)&1 != 0
+	off := 1 - kMin // vf[off+k] and vb[off+k]; needs kMax-kMin+3 slots
+
+	fMin, fMax := fMid, fMid
+	bMin, bMax := bMid, bMid
+	d.vf[off+fMid] = aLo
+	d.vb[off+bMid] = aHi
+	for cost := 1; ; cost++ {
+		// Forward search from the top-left corner, widening by one diagonal per side
+		if fMin > kMin {
+			fMin--
+			d.vf[off+fMin-1] = -1
+		} else {
+			fMin++
+		}
+		if fMax < kMax {
+			fMax++
+			d.vf[off+fMax+1] = -1
+		} else {
+			fMax--
+		}
+		for k := fMax; k >= fMin; k -= 2 {
+			x := d.vf[off+k+1]
+			if lo := d.vf[off+k-1]; lo >= x {
+				x = lo + 1
+			}
+			y := x - k
+			for x < aHi && y < bHi && d.a[x] == d.b[y] {
+				x++
+				y++
+			}
+			d.vf[off+k] = x
+			if odd && bMin <= k && k <= bMax && d.vb[off+k] <= x {
+				return x, y
+			}
+		}
+
+		// Backward search from the bottom-right corner
+		if bMin > kMin {
+			bMin--
+			d.vb[off+bMin-1] = math.MaxInt
+		} else {
+			bMin++
+		}
+		if bMax < kMax {
+			bMax++
+			d.vb[off+bMax+1] = math.MaxInt
+		} else {
+			bMax--
+		}
+		for k := bMax; k >= bMin; k -= 2 {
+			x := d.vb[off+k-1]
+			if hi := d.vb[off+k+1]; x >= hi {
+				x = hi - 1
+			}
+			y := x - k
+			for x > aLo && y > bLo && d.a[x-1] == d.b[y-1] {
+				x--
+				y--
+			}
+			d.vb[off+k] = x
+			if !odd && fMin <= k && k <= fMax && x <= d.vf[off+k] {
+				return x, y
+			}
+		}
+
+		if cost >= d.tooExpensive {
+			return d.furthest(aLo, aHi, bLo, bHi, off, fMin, fMax, bMin, bMax)
+		}
+	}
+}
+
+// furthest returns the point the forward search has brought furthest from
+// the top-left corner, or the one the backward search has brought furthest
+// from the bottom-right corner, whichever got further
+func (d *differ) furthest(aLo, aHi, bLo, bHi, off, fMin, fMax, bMin, bMax int) (int, int) {
+	fBest, fx := -1, 0
+	for k := fMax; k >= fMin; k -= 2 {
+		x := min(d.vf[off+k], aHi)
+		y := x - k
+		if y > bHi {
+			x, y = bHi+k, bHi
+		}
+		if x+y > fBest {
+			fBest, fx = x+y, x
+		}
+	}
+	bBest, bx := math.MaxInt, 0
+	for k := bMax; k >= bMin; k -= 2 {
+		x := max(d.vb[off+k], aLo)
+		y := x - k
+		if y < bLo {
+			x, y = bLo+k, bLo
+		}
+		if x+y < bBest {
+			bBest, bx = x+y, x
+		}
+	}
+	if aHi+bHi-bBest < fBest-(aLo+bLo) {
+		return fx, fBest - fx
+	}
+	return bx, bBest - bx
+}
@@ -0,0 +1,343 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding identifies a character encoding fixml can read and write.
+// Documents are always processed as UTF-8; other encodings are converted
+// while reading and, with Options.KeepEncoding, converted back on output.
+type Encoding string
+
+const (
+	EncodingUTF8        Encoding = "UTF-8"
+	EncodingUTF16LE     Encoding = "UTF-16LE"
+	EncodingUTF16BE     Encoding = "UTF-16BE"
+	EncodingISO88591    Encoding = "ISO-8859-1"
+	EncodingWindows1252 Encoding = "windows-1252"
+)
+
+// ENCODING_ALIASES maps the lowercased encoding names accepted in an XML
+// declaration to the encoding they select. UTF-16 names map to little endian;
+// the actual byte order always comes from the BOM or the first bytes.
+var ENCODING_ALIASES = map[string]Encoding{
+	"utf-8":        EncodingUTF8,
+	"utf8":         EncodingUTF8,
+	"us-ascii":     EncodingUTF8,
+	"ascii":        EncodingUTF8,
+	"utf-16":       EncodingUTF16LE,
+	"utf-16le":     EncodingUTF16LE,
+	"utf-16be":     EncodingUTF16BE,
+	"iso-8859-1":   EncodingISO88591,
+	"iso_8859-1":   EncodingISO88591,
+	"iso8859-1":    EncodingISO88591,
+	"latin1":       EncodingISO88591,
+	"l1":           EncodingISO88591,
+	"windows-1252": EncodingWindows1252,
+	"cp1252":       EncodingWindows1252,
+	"x-cp1252":     EncodingWindows1252,
+}
+
+// WINDOWS_1252_HIGH holds the characters of windows-1252 bytes 0x80-0x9F.
+// The five unassigned bytes map to the C1 control of the same value, as
+// browsers do, so every byte round-trips.
+var WINDOWS_1252_HIGH = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// declarationName is the name written in the encoding declaration
+func (e Encoding) declarationName() string {
+	switch e {
+	case EncodingUTF8:
+		return "utf-8" // As in XML_DECLARATION
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return "UTF-16"
+	}
+	return string(e)
+}
+
+// sameFamily reports whether a declaration naming e is correct for other;
+// the byte order of UTF-16 is not part of the comparison
+func (e Encoding) sameFamily(other Encoding) bool {
+	return e.declarationName() == other.declarationName()
+}
+
+// xmlDeclaration returns XML_DECLARATION naming the given encoding
+func xmlDeclaration(e Encoding) string {
+	return strings.Replace(XML_DECLARATION, `"utf-8"`, `"`+e.declarationName()+`"`, 1)
+}
+
+// detectEncoding determines the encoding of the raw input from its byte
+// order mark, the byte pattern of a UTF-16 '<', or the encoding named in the
+// XML declaration, in that order. It returns the encoding, the length of the
+// BOM and the declared name, if any. Unknown declared names select UTF-8.
+func detectEncoding(raw *bufio.Reader) (Encoding, int, string) {
+	head, _ := raw.Peek(IO_CHUNK_SIZE)
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8, 3, declaredEncoding(head[3:])
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE, 2, ""
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE, 2, ""
+	case bytes.HasPrefix(head, []byte{'<', 0}):
+		return EncodingUTF16LE, 0, ""
+	case bytes.HasPrefix(head, []byte{0, '<'}):
+		return EncodingUTF16BE, 0, ""
+	}
+
+	declared := declaredEncoding(head)
+	if e, ok := ENCODING_ALIASES[strings.ToLower(declared)]; ok && e != EncodingUTF16LE && e != EncodingUTF16BE {
+		return e, 0, declared
+	}
+	return EncodingUTF8, 0, declared
+}
+
+// declaredEncoding returns the value of the encoding pseudo-attribute of the
+// XML declaration at the start of head, or "" when there is none
+func declaredEncoding(head []byte) string {
+	start, end := findDeclaredEncoding(head)
+	return string(head[start:end])
+}
+
+// findDeclaredEncoding locates the encoding value of the XML declaration at
+// the start of head (after optional whitespace). Both offsets are 0 when
+// there is no declaration or it names no encoding.
+func findDeclaredEncoding(head []byte) (int, int) {
+	i := 0
+	for i < len(head) && head[i] <= WHITESPACE_THRESHOLD {
+		i++
+	}
+	if !bytes.HasPrefix(head[i:], []byte("<?xml")) {
+		return 0, 0
+	}
+	end := bytes.Index(head[i:], []byte("?>"))
+	if end < 0 {
+		return 0, 0
+	}
+	decl := head[:i+end]
+
+	at := bytes.Index(decl[i:], []byte("encoding"))
+	if at < 0 {
+		return 0, 0
+	}
+	j := skipSpace(string(decl), i+at+len("encoding"))
+	if j >= len(decl) || decl[j] != '=' {
+		return 0, 0
+	}
+	j = skipSpace(string(decl), j+1)
+	if j >= len(decl) || (decl[j] != '"' && decl[j] != '\'') {
+		return 0, 0
+	}
+	n := bytes.IndexByte(decl[j+1:], decl[j])
+	if n < 0 {
+		return 0, 0
+	}
+	return j + 1, j + 1 + n
+}
+
+// rewriteDeclaration makes the encoding declaration at the start of the
+// cleaned stream name e. Declarations naming an unknown encoding, or already
+// naming e, are left alone. It reports whether the declaration was changed.
+func rewriteDeclaration(reader *bufio.Reader, e Encoding) (*bufio.Reader, bool) {
+	head, _ := reader.Peek(IO_CHUNK_SIZE)
+	start, end := findDeclaredEncoding(head)
+	declared, ok := ENCODING_ALIASES[strings.ToLower(string(head[start:end]))]
+	if !ok || declared.sameFamily(e) {
+		return reader, false
+	}
+
+	prefix := string(head[:start]) + e.declarationName()
+	reader.Discard(end)
+	return bufio.NewReaderSize(io.MultiReader(strings.NewReader(prefix), reader), IO_CHUNK_SIZE), true
+}
+
+// decodingReader converts a stream in a supported encoding to UTF-8.
+// A UTF-16 code unit or surrogate pair split across reads is carried over;
+// unpaired surrogates and a trailing odd byte become U+FFFD.
+type decodingReader struct {
+	src   io.Reader
+	enc   Encoding
+	raw   []byte
+	carry int    // Bytes of an incomplete code unit kept at the start of raw
+	high  uint16 // High surrogate waiting for its pair, or 0
+	buf   []byte
+	out   []byte // Decoded bytes not returned yet
+	err   error
+}
+
+func newDecodingReader(src io.Reader, enc Encoding) *decodingReader {
+	return &decodingReader{src: src, enc: enc, raw: make([]byte, IO_CHUNK_SIZE)}
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.src.Read(d.raw[d.carry:])
+		d.err = err
+		d.decode(d.raw[:d.carry+n], err != nil)
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decodingReader) decode(chunk []byte, final bool) {
+	d.buf = d.buf[:0]
+	switch d.enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		n := len(chunk) &^ 1
+		for i := 0; i < n; i += 2 {
+			u := uint16(chunk[i]) | uint16(chunk[i+1])<<8
+			if d.enc == EncodingUTF16BE {
+				u = uint16(chunk[i])<<8 | uint16(chunk[i+1])
+			}
+			d.unit(u)
+		}
+		d.carry = len(chunk) - n
+		if d.carry > 0 {
+			d.raw[0] = chunk[n]
+		}
+		if final {
+			if d.high != 0 || d.carry > 0 {
+				d.buf = utf8.AppendRune(d.buf, utf8.RuneError)
+			}
+			d.high, d.carry = 0, 0
+		}
+	default:
+		for _, b := range chunk {
+			switch {
+			case b < utf8.RuneSelf:
+				d.buf = append(d.buf, b)
+			case b < 0xA0 && d.enc == EncodingWindows1252:
+				d.buf = utf8.AppendRune(d.buf, WINDOWS_1252_HIGH[b-0x80])
+			default:
+				d.buf = utf8.AppendRune(d.buf, rune(b))
+			}
+		}
+	}
+	d.out = d.buf
+}
+
+// unit decodes one UTF-16 code unit
+func (d *decodingReader) unit(u uint16) {
+	switch {
+	case u >= 0xD800 && u < 0xDC00:
+		if d.high != 0 {
+			d.buf = utf8.AppendRune(d.buf, utf8.RuneError)
+		}
+		d.high = u
+	case u >= 0xDC00 && u < 0xE000:
+		r := utf8.RuneError
+		if d.high != 0 {
+			r = utf16.DecodeRune(rune(d.high), rune(u))
+		}
+		d.high = 0
+		d.buf = utf8.AppendRune(d.buf, r)
+	default:
+		if d.high != 0 {
+			d.buf = utf8.AppendRune(d.buf, utf8.RuneError)
+			d.high = 0
+		}
+		d.buf = utf8.AppendRune(d.buf, rune(u))
+	}
+}
+
+// encodingWriter converts the UTF-8 output back to a supported encoding.
+// Characters a single-byte encoding cannot represent are written as
+// character references; since every character of the output comes from
+// input in the same encoding, this only happens for text fixml inserts.
+type encodingWriter struct {
+	w       io.Writer
+	enc     Encoding
+	partial []byte // Start of a UTF-8 sequence split across writes
+	buf     []byte
+}
+
+func newEncodingWriter(w io.Writer, enc Encoding) *encodingWriter {
+	return &encodingWriter{w: w, enc: enc}
+}
+
+func (e *encodingWriter) Write(p []byte) (int, error) {
+	data := p
+	if len(e.partial) > 0 {
+		data = append(e.partial, p...)
+		e.partial = nil
+	}
+
+	e.buf = e.buf[:0]
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			e.partial = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		e.encode(r)
+		data = data[size:]
+	}
+
+	if _, err := e.w.Write(e.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (e *encodingWriter) encode(r rune) {
+	switch e.enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		if r > 0xFFFF {
+			hi, lo := utf16.EncodeRune(r)
+			e.unit(uint16(hi))
+			e.unit(uint16(lo))
+		} else {
+			e.unit(uint16(r))
+		}
+		return
+	case EncodingWindows1252:
+		if r >= 0x80 && r < 0xA0 && WINDOWS_1252_HIGH[r-0x80] != r {
+			break // Only the unassigned bytes keep their C1 value
+		}
+		if r < 0x100 {
+			e.buf = append(e.buf, byte(r))
+			return
+		}
+		for i, c := range WINDOWS_1252_HIGH {
+			if c == r {
+				e.buf = append(e.buf, byte(0x80+i))
+				return
+			}
+		}
+	case EncodingISO88591:
+		if r < 0x100 {
+			e.buf = append(e.buf, byte(r))
+			return
+		}
+	default:
+		e.buf = utf8.AppendRune(e.buf, r)
+		return
+	}
+
+	e.buf = append(e.buf, "&#"...)
+	e.buf = strconv.AppendInt(e.buf, int64(r), 10)
+	e.buf = append(e.buf, ';')
+}
+
+// unit appends one UTF-16 code unit in the writer's byte order
+func (e *encodingWriter) unit(u uint16) {
+	if e.enc == EncodingUTF16BE {
+		e.buf = append(e.buf, byte(u>>8), byte(u))
+	} else {
+		e.buf = append(e.buf, byte(u), byte(u>>8))
+	}
+}
@@ -0,0 +1,134 @@
+package fixml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeAs returns s, which is UTF-8, in enc, preceded by a byte order mark
+// if bom is set
+func encodeAs(t *testing.T, s string, enc Encoding, bom bool) []byte {
+	t.Helper()
+	var b []byte
+	if bom {
+		s = "\uFEFF" + s
+	}
+	switch enc {
+	case EncodingUTF8:
+		return []byte(s)
+	case EncodingUTF16LE, EncodingUTF16BE:
+		for _, u := range utf16.Encode([]rune(s)) {
+			if enc == EncodingUTF16BE {
+				b = append(b, byte(u>>8), byte(u))
+			} else {
+				b = append(b, byte(u), byte(u>>8))
+			}
+		}
+	case EncodingISO88591:
+		for _, r := range s {
+			if r > 0xFF {
+				t.Fatalf("%q is not in ISO-8859-1", r)
+			}
+			b = append(b, byte(r))
+		}
+	case EncodingWindows1252:
+	runes:
+		for _, r := range s {
+			for i, c := range WINDOWS_1252_HIGH {
+				if c == r {
+					b = append(b, byte(0x80+i))
+					continue runes
+				}
+			}
+			if r > 0xFF {
+				t.Fatalf("%q is not in windows-1252", r)
+			}
+			b = append(b, byte(r))
+		}
+	}
+	return b
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		enc      Encoding
+		bom      bool
+		declared string // Encoding named in the declaration of input and output
+		body     string // Content after the declaration, as UTF-8
+	}{
+		{"UTF-16LE with BOM", EncodingUTF16LE, true, "UTF-16", "<a>\n<b>é ☃ 𝄞</b>\n</a>\n"},
+		{"UTF-16BE with BOM", EncodingUTF16BE, true, "UTF-16", "<a>\n<b>é ☃ 𝄞</b>\n</a>\n"},
+		{"UTF-16LE without BOM", EncodingUTF16LE, false, "UTF-16", "<a>\n<b>é ☃ 𝄞</b>\n</a>\n"},
+		{"UTF-16BE without BOM", EncodingUTF16BE, false, "UTF-16", "<a>\n<b>é ☃ 𝄞</b>\n</a>\n"},
+		{"ISO-8859-1", EncodingISO88591, false, "ISO-8859-1", "<a>\n<b>café ÿ</b>\n</a>\n"},
+		{"windows-1252", EncodingWindows1252, false, "windows-1252", "<a>\n<b>5 € – “q”</b>\n</a>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decl := `<?xml version="1.0" encoding="` + tt.declared + `"?>` + "\n"
+			formatted := strings.Replace(tt.body, "<b>", "  <b>", 1)
+			input := encodeAs(t, decl+tt.body, tt.enc, tt.bom)
+
+			// Written back in the input's encoding
+			var out bytes.Buffer
+			res, err := Process(bytes.NewReader(input), &out, Options{KeepEncoding: true})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if res.Encoding != tt.enc {
+				t.Errorf("Encoding = %s, want %s", res.Encoding, tt.enc)
+			}
+			if want := encodeAs(t, decl+formatted, tt.enc, tt.bom); !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("KeepEncoding output = %q, want %q", out.Bytes(), want)
+			}
+			if len(res.Warnings) > 0 {
+				t.Errorf("unexpected warnings: %+v", res.Warnings)
+			}
+
+			// Converted to UTF-8, with the declaration following
+			out.Reset()
+			res, err = Process(bytes.NewReader(input), &out, Options{})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if want := XML_DECLARATION + formatted; out.String() != want {
+				t.Errorf("UTF-8 output = %q, want %q", out.String(), want)
+			}
+			converted := "Converted from " + string(tt.enc) + " to UTF-8"
+			if len(res.Fixes) == 0 || res.Fixes[0] != converted {
+				t.Errorf("Fixes = %q, want %q first", res.Fixes, converted)
+			}
+		})
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	input := `<?xml version="1.0" encoding="Shift_JIS"?>` + "\n<a>\n<b>x</b>\n</a>\n"
+	var out bytes.Buffer
+	res, err := Process(strings.NewReader(input), &out, Options{KeepEncoding: true})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if res.Encoding != EncodingUTF8 {
+		t.Errorf("Encoding = %s, want %s", res.Encoding, EncodingUTF8)
+	}
+	if want := strings.Replace(input, "<b>", "  <b>", 1); out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	var found *Warning
+	for i, w := range res.Warnings {
+		if w.ID == WARNING_UNSUPPORTED_ENCODING {
+			found = &res.Warnings[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("no %s warning in %+v", WARNING_UNSUPPORTED_ENCODING, res.Warnings)
+	}
+	if !strings.Contains(found.Message, `"Shift_JIS"`) || found.Line != 1 {
+		t.Errorf("warning = %+v, want Shift_JIS named on line 1", *found)
+	}
+}
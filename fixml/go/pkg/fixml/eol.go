@@ -0,0 +1,85 @@
+package fixml
+
+import "io"
+
+// EOLMode selects the line ending written to the output.
+type EOLMode int
+
+const (
+	// EOLLF writes LF line endings.
+	EOLLF EOLMode = iota
+	// EOLCRLF writes CRLF line endings.
+	EOLCRLF
+	// EOLAuto writes the line ending used by most lines of the input. Input
+	// is streamed, so only the first IO_CHUNK_SIZE bytes are considered
+	// (the whole document with Options.Organize or Options.Profile).
+	EOLAuto
+)
+
+// FinalNewlineMode selects whether the output ends with a line break.
+type FinalNewlineMode int
+
+const (
+	// FinalNewlineEnsure ends non-empty output with a line break.
+	FinalNewlineEnsure FinalNewlineMode = iota
+	// FinalNewlineKeep ends the output with a line break only if the input
+	// ended with one.
+	FinalNewlineKeep
+	// FinalNewlineRemove never ends the output with a line break.
+	FinalNewlineRemove
+)
+
+// lineEndWriter applies the line ending and final newline policy to the
+// formatted output, which always uses LF and ends every line with one.
+// The last LF written is held back until more output follows or finish
+// decides whether the document ends with it.
+type lineEndWriter struct {
+	w     io.Writer
+	eol   string
+	final FinalNewlineMode
+	held  bool // An LF ending the output so far is held back
+	buf   []byte
+}
+
+func newLineEndWriter(w io.Writer, eol string, final FinalNewlineMode) *lineEndWriter {
+	return &lineEndWriter{w: w, eol: eol, final: final}
+}
+
+func (l *lineEndWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	l.buf = l.buf[:0]
+	if l.held {
+		l.buf = append(l.buf, l.eol...)
+	}
+	body := p
+	l.held = body[len(body)-1] == '\n'
+	if l.held {
+		body = body[:len(body)-1]
+	}
+	for _, b := range body {
+		if b == '\n' {
+			l.buf = append(l.buf, l.eol...)
+		} else {
+			l.buf = append(l.buf, b)
+		}
+	}
+
+	if _, err := l.w.Write(l.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// finish writes the held line break if the policy keeps it; inputNewline
+// reports whether the input ended with a line break
+func (l *lineEndWriter) finish(inputNewline bool) error {
+	if !l.held || l.final == FinalNewlineRemove || (l.final == FinalNewlineKeep && !inputNewline) {
+		return nil
+	}
+	l.held = false
+	_, err := io.WriteString(l.w, l.eol)
+	return err
+}
@@ -0,0 +1,69 @@
+package fixml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLineEndings(t *testing.T) {
+	lf := XML_DECLARATION + "<a>\n  <b>x</b>\n</a>\n"
+	crlf := strings.ReplaceAll(lf, "\n", "\r\n")
+	mixed := XML_DECLARATION + "<a>\n  <b>x</b>\n  <c/>\n</a>\n"
+	tests := []struct {
+		name  string
+		input string
+		eol   EOLMode
+		want  string
+	}{
+		{"CRLF to LF", crlf, EOLLF, lf},
+		{"LF to CRLF", lf, EOLCRLF, crlf},
+		{"auto keeps CRLF", crlf, EOLAuto, crlf},
+		{"auto keeps LF", lf, EOLAuto, lf},
+		{"auto follows most lines", XML_DECLARATION + "<a>\r\n<b>x</b>\r\n<c/>\n</a>\r\n", EOLAuto, strings.ReplaceAll(mixed, "\n", "\r\n")},
+		{"auto with a minority of CRLF", XML_DECLARATION + "<a>\n<b>x</b>\r\n<c/>\n</a>\n", EOLAuto, mixed},
+		{"lone CR is a line break", strings.ReplaceAll(lf, "\n", "\r"), EOLLF, lf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, err := Process(strings.NewReader(tt.input), &out, Options{EOL: tt.eol}); err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFinalNewline(t *testing.T) {
+	body := XML_DECLARATION + "<a>\n  <b>x</b>\n</a>"
+	tests := []struct {
+		final   FinalNewlineMode
+		eol     EOLMode
+		newline string // After the input's last line
+		want    string // After the output's last line
+	}{
+		{FinalNewlineEnsure, EOLLF, "", "\n"},
+		{FinalNewlineEnsure, EOLLF, "\n\n\n", "\n"},
+		{FinalNewlineKeep, EOLLF, "", ""},
+		{FinalNewlineKeep, EOLLF, "\n", "\n"},
+		{FinalNewlineKeep, EOLCRLF, "\n", "\r\n"},
+		{FinalNewlineRemove, EOLLF, "\n", ""},
+		{FinalNewlineRemove, EOLCRLF, "\r\n", ""},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if _, err := Process(strings.NewReader(body+tt.newline), &out, Options{EOL: tt.eol, FinalNewline: tt.final}); err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		want := body + tt.want
+		if tt.eol == EOLCRLF {
+			want = strings.ReplaceAll(body, "\n", "\r\n") + tt.want
+		}
+		if out.String() != want {
+			t.Errorf("FinalNewline %d with input ending %q: output = %q, want %q", tt.final, tt.newline, out.String(), want)
+		}
+	}
+}
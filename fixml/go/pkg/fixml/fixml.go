@@ -0,0 +1,407 @@
+// Package fixml is the importable core of the FIXML Go implementation.
+//
+// It formats XML while preserving the original structure: every line keeps
+// its content, indentation is normalized to two spaces per nesting level and
+// repeated sibling elements are removed. The fixml command is a thin wrapper around
+// Process and ProcessFile, so services can format XML in-process with the
+// same results as the CLI.
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Standard constants - consistent across all implementations
+const XML_DECLARATION = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+const MAX_INDENT_LEVELS = 64      // Nesting levels with precomputed indentation
+const DEFAULT_INDENT_WIDTH = 2    // Spaces per nesting level
+const ESTIMATED_LINE_LENGTH = 50  // Average characters per line estimate
+const MIN_HASH_CAPACITY = 256     // Minimum deduplication hash capacity
+const MAX_HASH_CAPACITY = 4096    // Maximum deduplication hash capacity
+const WHITESPACE_THRESHOLD = 32   // ASCII values <= this are whitespace
+const FILE_PERMISSIONS = 0644     // Standard file permissions
+const DIR_PERMISSIONS = 0755      // Permissions of directories fixml creates
+const IO_CHUNK_SIZE = 65536       // 64KB chunks for I/O operations
+const MAX_SUBTREE_BYTES = 65536   // Largest multi-line element held back for deduplication
+const MAX_COMPARE_BYTES = 1048576 // Lead of input over output, or back, compared byte for byte
+const MAX_REFERENCE_LENGTH = 10   // Longest character or entity reference, e.g. &#x10FFFF;
+
+// DedupMode selects how repeated elements are removed.
+type DedupMode int
+
+const (
+	// DedupSiblings removes an element only when an identical sibling
+	// appeared earlier under the same parent element.
+	DedupSiblings DedupMode = iota
+	// DedupGlobal removes an element when an identical one appeared anywhere
+	// earlier in the document, regardless of its parent.
+	DedupGlobal
+	// DedupOff keeps every element.
+	DedupOff
+)
+
+// NodePolicy selects how deduplication treats comments, CDATA sections or
+// processing instructions.
+type NodePolicy int
+
+const (
+	// NodeKeep never removes the node: a line or element containing it is
+	// not removed as a duplicate.
+	NodeKeep NodePolicy = iota
+	// NodeDedup removes the node with the line or element it belongs to when
+	// that repeats an earlier sibling; a line holding only such nodes is
+	// compared like a line of elements.
+	NodeDedup
+)
+
+// Options controls a single formatting run.
+// The zero value matches the CLI defaults.
+type Options struct {
+	// Replace overwrites the input file instead of writing a sibling
+	// .organized file. Only ProcessFile honours it.
+	Replace bool
+	// Backup is where ProcessFile keeps the original file before replacing
+	// it; empty keeps no copy. An existing file at that path is overwritten.
+	Backup string
+	// FixWarnings applies the automatic fix for every reported warning.
+	FixWarnings bool
+	// Dedup selects the deduplication behavior.
+	Dedup DedupMode
+	// ReportDuplicates lists every removed element in Result.Duplicates.
+	// Otherwise removed elements are only counted, so memory stays flat
+	// however many there are.
+	ReportDuplicates bool
+	// Organize groups related sibling elements before formatting. The whole
+	// document is held in memory, and Duplicate line numbers refer to the
+	// organized document.
+	Organize bool
+	// OrganizeOrder lists the element names Organize groups, in the order the
+	// groups appear; empty selects DEFAULT_ORGANIZE_ORDER.
+	OrganizeOrder []string
+	// Strict refuses to write any output when the input is not well-formed.
+	// The output is held in memory until the whole input has been checked.
+	Strict bool
+	// Verify refuses to write any output unless it reads as the same XML as
+	// the input, apart from whitespace and the elements the run removed, and
+	// formatting it again leaves it unchanged. Input and output are held in
+	// memory.
+	Verify bool
+	// Canonical writes the canonical form of the processed document instead
+	// (see Canonicalize). The output is held in memory and is always UTF-8
+	// with LF line endings; KeepEncoding, EOL and FinalNewline are ignored.
+	Canonical CanonicalMode
+	// KeepEncoding writes the output in the encoding of the input, with its
+	// BOM, instead of UTF-8. The XML declaration names the output encoding
+	// either way.
+	KeepEncoding bool
+	// EOL selects the line ending of the output.
+	EOL EOLMode
+	// FinalNewline selects whether the output ends with a line break.
+	FinalNewline FinalNewlineMode
+	// IndentWidth is the number of spaces per nesting level; 0 selects
+	// DEFAULT_INDENT_WIDTH.
+	IndentWidth int
+	// IndentTabs indents with one tab per nesting level instead of spaces.
+	IndentTabs bool
+	// AttributeIndent is the number of levels by which lines continuing a
+	// tag onto following lines are indented past the tag; 0 selects 1.
+	// ATTRIBUTE_INDENT_ALIGN lines them up with the tag's first attribute.
+	AttributeIndent int
+	// DedupExempt lists element names that are never removed as duplicates.
+	DedupExempt []string
+	// PreserveSpace lists element names whose whitespace is significant, as
+	// if they had xml:space="preserve". Content inside such elements is
+	// written exactly as read: it is neither reindented nor deduplicated.
+	PreserveSpace []string
+	// Only and Skip select the elements that are reindented and
+	// deduplicated, by paths in the XPath subset of ParseSelector. With
+	// Only, just the elements matching one of its paths are, with everything
+	// inside them; Skip leaves out the elements matching its paths, with
+	// everything inside them. Lines outside the selection are written as
+	// read, and no element containing them is removed.
+	Only []string
+	Skip []string
+	// Comments, CData and ProcInsts select the deduplication policy of
+	// comments, CDATA sections and processing instructions.
+	Comments  NodePolicy
+	CData     NodePolicy
+	ProcInsts NodePolicy
+	// Profile enables processing for a kind of document, such as
+	// PackageReference deduplication for MSBuild projects. The whole document
+	// is held in memory for ProfileMSBuild.
+	Profile Profile
+	// VersionPolicy selects how ProfileMSBuild handles a package referenced
+	// with different versions.
+	VersionPolicy VersionPolicy
+	// Warnings enables (true) or disables (false) lint rules by ID; rules
+	// not listed run unless RuleInfo.Optional is set. Disabled rules are
+	// neither reported nor fixed.
+	Warnings map[string]bool
+}
+
+// ATTRIBUTE_INDENT_ALIGN is the Options.AttributeIndent value that aligns
+// continued attributes with the first attribute of their tag
+const ATTRIBUTE_INDENT_ALIGN = -1
+
+// Warning describes an XML best practice violation found in the input.
+type Warning struct {
+	ID       string `json:"id"`       // ID of the rule that reported it
+	Category string `json:"category"` // Short tag shown in brackets, e.g. "XML"
+	Severity string `json:"severity"` // "info", "warning" or "error"
+	Message  string `json:"message"`
+	Fix      string `json:"fix"`            // Human-readable remedy
+	Line     int    `json:"line,omitempty"` // 1-based position; 0 for the document as a whole
+	Col      int    `json:"col,omitempty"`
+}
+
+// Duplicate describes an element removed because an identical one was kept
+// earlier. Line numbers refer to the input and are 1-based.
+type Duplicate struct {
+	Line        int    `json:"line"`         // Where the removed element starts
+	Text        string `json:"text"`         // The removed element as formatted
+	DuplicateOf int    `json:"duplicate_of"` // Where the element that was kept starts
+}
+
+// Result carries statistics and diagnostics of a formatting run.
+type Result struct {
+	Warnings          []Warning      // At most MAX_WARNINGS_PER_RULE per rule
+	RuleCounts        map[string]int // Findings per rule ID, including those not kept in Warnings
+	Fixes             []string       // Descriptions of the fixes that were applied
+	HasXMLDeclaration bool
+	Encoding          Encoding // Encoding detected in the input
+	DuplicatesRemoved int
+	Duplicates        []Duplicate   // One entry per removed element, in input order, with Options.ReportDuplicates
+	Errors            []SyntaxError // Well-formedness errors, at most MAX_SYNTAX_ERRORS
+	OutputFile        string        // Path written by ProcessFile; empty for Process
+	BackupFile        string        // Copy of the original kept by ProcessFile, if any
+	Changed           bool          // The output differs from the input byte for byte
+}
+
+// Process formats the XML read from r and writes the result to w.
+// Diagnostics are returned in the Result instead of being printed.
+//
+// The document is streamed: input is read in IO_CHUNK_SIZE chunks and output
+// is written incrementally, so peak memory does not grow with the input size
+// (except with Organize and ProfileMSBuild, which need the whole document).
+func Process(r io.Reader, w io.Writer, opts Options) (Result, error) {
+	var res Result
+	if _, err := newSelection(opts.Only, opts.Skip); err != nil {
+		return res, err
+	}
+	if opts.Canonical != CanonicalNone {
+		opts.KeepEncoding = false
+		opts.EOL = EOLLF
+		opts.FinalNewline = FinalNewlineEnsure
+	}
+
+	// Compare input and output on the fly to report whether anything changed
+	size := sizeHint(r)
+	cmp := &compareWriter{}
+	r = io.TeeReader(r, compareSide{cmp, false})
+	var input bytes.Buffer
+	if opts.Verify {
+		r = io.TeeReader(r, &input)
+	}
+	w = io.MultiWriter(w, compareSide{cmp, true})
+
+	// Well-formedness is checked on the input tokens; with Organize or a
+	// profile they are checked before the document is rewritten so positions
+	// stay accurate
+	v := &validator{}
+	formatChecks := v
+	clean := newCleanReader(r)
+	clean.detect()
+	reader := bufio.NewReaderSize(clean, IO_CHUNK_SIZE)
+
+	// The document is processed as UTF-8; its declaration has to name the
+	// encoding it is written in
+	res.Encoding = clean.encoding
+	out := outputEncoding(opts, &res)
+	if out != res.Encoding {
+		res.Fixes = append(res.Fixes, fmt.Sprintf("Converted from %s to %s", res.Encoding, out))
+		reader, _ = rewriteDeclaration(reader, out)
+	}
+
+	hoist := opts.ruleEnabled(repeatedNamespaceRule{}.Info())
+	var lines []int // Input line of each line Organize leaves for the formatter
+	if opts.Organize || opts.Profile == ProfileMSBuild || hoist {
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return res, fmt.Errorf("error reading content: %v", err)
+		}
+		// The first pass over the document sees the input tokens
+		checks := v
+		if opts.Profile == ProfileMSBuild {
+			content = resolvePackages(content, opts, checks, &res)
+			checks = nil
+		}
+		if hoist {
+			content = hoistNamespaces(content, opts, checks, &res)
+			checks = nil
+		}
+		if opts.Organize {
+			content, lines = organize(content, opts.OrganizeOrder, checks)
+		}
+		reader = bufio.NewReaderSize(bytes.NewReader(content), IO_CHUNK_SIZE)
+		formatChecks = nil
+	}
+	res.HasXMLDeclaration = hasXMLDeclaration(reader)
+
+	// Strict mode holds the output back until the input is known to be
+	// well-formed, Verify until the output has been checked, and Canonical
+	// until the whole document can be canonicalized
+	dest := w
+	var held bytes.Buffer
+	if opts.Strict || opts.Verify || opts.Canonical != CanonicalNone {
+		dest = &held
+	}
+
+	if out != EncodingUTF8 {
+		dest = newEncodingWriter(dest, out)
+	}
+
+	// Lines are formatted with LF; other line endings and final newline
+	// policies are applied on the way out
+	eol := "\n"
+	switch opts.EOL {
+	case EOLCRLF:
+		eol = "\r\n"
+	case EOLAuto:
+		eol = clean.lineEnding() // The first chunk has been read by now
+	}
+	var lineEnds *lineEndWriter
+	if eol != "\n" || opts.FinalNewline != FinalNewlineEnsure {
+		lineEnds = newLineEndWriter(dest, eol, opts.FinalNewline)
+		dest = lineEnds
+	}
+
+	// Just process as text to preserve original structure and avoid XML parsing issues
+	output := bufio.NewWriterSize(dest, IO_CHUNK_SIZE)
+	if opts.KeepEncoding && clean.bom {
+		output.WriteString("\uFEFF") // Encoded as the BOM of the output encoding
+	}
+	if err := processAsText(opts, reader, output, size, formatChecks, lines, &res); err != nil {
+		return res, err
+	}
+	res.Errors = v.finish()
+
+	if err := output.Flush(); err != nil {
+		return res, fmt.Errorf("could not write output: %v", err)
+	}
+	if lineEnds != nil {
+		if err := lineEnds.finish(clean.endNewline); err != nil {
+			return res, fmt.Errorf("could not write output: %v", err)
+		}
+	}
+	if opts.Strict {
+		if len(res.Errors) > 0 {
+			return res, fmt.Errorf("input is not well-formed (%d errors); no output written", len(res.Errors))
+		}
+	}
+	if opts.Verify {
+		if err := verify(input.Bytes(), held.Bytes(), opts, &res); err != nil {
+			return res, fmt.Errorf("output failed verification: %v; no output written", err)
+		}
+	}
+	switch {
+	case opts.Canonical != CanonicalNone:
+		if err := Canonicalize(bytes.NewReader(held.Bytes()), w, opts.Canonical); err != nil {
+			return res, fmt.Errorf("could not canonicalize output: %v", err)
+		}
+	case opts.Strict || opts.Verify:
+		if _, err := w.Write(held.Bytes()); err != nil {
+			return res, fmt.Errorf("could not write output: %v", err)
+		}
+	}
+
+	res.Changed = cmp.differs()
+	return res, nil
+}
+
+// ruleEnabled reports whether a rule is run; rules not listed in Warnings
+// run unless they are optional
+func (opts Options) ruleEnabled(info RuleInfo) bool {
+	if enabled, ok := opts.Warnings[info.ID]; ok {
+		return enabled
+	}
+	return !info.Optional
+}
+
+// nodePolicy returns the deduplication policy of a token kind; ok is false
+// for kinds deduplication always handles, such as tags and text
+func (opts Options) nodePolicy(kind TokenKind) (policy NodePolicy, ok bool) {
+	switch kind {
+	case TokenComment:
+		return opts.Comments, true
+	case TokenCData:
+		return opts.CData, true
+	case TokenProcInst:
+		return opts.ProcInsts, true
+	}
+	return NodeKeep, false
+}
+
+// outputEncoding is the encoding the output of a run is written in
+func outputEncoding(opts Options, res *Result) Encoding {
+	if opts.KeepEncoding {
+		return res.Encoding
+	}
+	return EncodingUTF8
+}
+
+// ProcessFile formats the file at path. The result is written next to it as
+// name.organized.ext, or over the original when opts.Replace is set.
+// Replacing is atomic and keeps the file's mode; see Options.Backup.
+func ProcessFile(path string, opts Options) (Result, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("could not read file '%s': %v", path, err)
+	}
+	defer in.Close()
+
+	var res Result
+	if opts.Replace {
+		err = replaceFile(path, opts.Backup, func(w io.Writer) error {
+			var err error
+			res, err = Process(in, w, opts)
+			return err
+		})
+		if err != nil {
+			return res, err
+		}
+		res.OutputFile, res.BackupFile = path, opts.Backup
+		return res, nil
+	}
+
+	outputFilename := getOutputFilename(path)
+	out, err := os.OpenFile(outputFilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FILE_PERMISSIONS)
+	if err != nil {
+		return Result{}, fmt.Errorf("could not write output file: %v", err)
+	}
+
+	res, err = Process(in, out, opts)
+	if cerr := out.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("could not write output file: %v", cerr)
+	}
+	if err != nil {
+		os.Remove(outputFilename)
+		return res, err
+	}
+
+	res.OutputFile = outputFilename
+	return res, nil
+}
+
+func getOutputFilename(inputFile string) string {
+	if lastDot := strings.LastIndex(inputFile, "."); lastDot != -1 {
+		name := inputFile[:lastDot]
+		ext := inputFile[lastDot+1:]
+		return name + ".organized." + ext
+	}
+	return inputFile + ".organized"
+}
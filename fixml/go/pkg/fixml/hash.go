@@ -0,0 +1,315 @@
+package fixml
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// computeSemanticHash computes a hash representing the semantic content without allocating normalized strings.
+// Tags are hashed in a canonical form: attributes are sorted by name, values
+// are requoted with double quotes and character and entity references are
+// decoded, so <Ref B='2' A="1"/> and <Ref A="1" B="2" /> hash alike. Outside
+// tags runs of whitespace count as a single space.
+// scope holds the namespaces in scope where s appears. Once namespaces are
+// involved, names are hashed expanded, so <a:item xmlns:a="u"/> and
+// <b:item xmlns:b="u"/> hash alike too.
+func computeSemanticHash(s string, scope namespaceScope) uint64 {
+	if len(s) == 0 {
+		return 0
+	}
+
+	var scopes *[]namespaceScope
+	if len(scope) > 0 || strings.Contains(s, "xmlns") {
+		scopes = &[]namespaceScope{scope}
+	}
+	h := newSemanticHasher()
+	prevSpace := false
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '<' && i+1 < len(s) && (isNameStart(s[i+1]) || s[i+1] == '/'):
+			if n := h.tag(s[i:], scopes); n > 0 {
+				i += n
+				prevSpace = false
+				continue
+			}
+		case c == '<' && strings.HasPrefix(s[i:], "<![CDATA["):
+			// CDATA content is literal, whitespace included
+			end := strings.Index(s[i:], "]]>")
+			if end < 0 {
+				end = len(s) - i - 3
+			}
+			h.writeString(s[i : i+end+3])
+			i += end + 3
+			prevSpace = false
+			continue
+		case c == '&':
+			i += h.reference(s[i:])
+			prevSpace = false
+			continue
+		case c <= WHITESPACE_THRESHOLD:
+			if !prevSpace {
+				h.writeByte(' ') // Normalize to single space
+				prevSpace = true
+			}
+			i++
+			continue
+		}
+
+		h.writeByte(c)
+		prevSpace = false
+		i++
+	}
+	return uint64(h)
+}
+
+// semanticHasher is an inline FNV-1a hash; writing single bytes through
+// hash.Hash would allocate for every byte
+type semanticHasher uint64
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+func newSemanticHasher() semanticHasher {
+	return fnvOffset64
+}
+
+func (h *semanticHasher) writeByte(c byte) {
+	*h = (*h ^ semanticHasher(c)) * fnvPrime64
+}
+
+func (h *semanticHasher) writeString(s string) {
+	for i := 0; i < len(s); i++ {
+		h.writeByte(s[i])
+	}
+}
+
+// attribute is a name/value pair of a parsed start tag; value is still encoded
+type attribute struct {
+	name, value string
+}
+
+// tag hashes the start, end or empty-element tag at the start of s in
+// canonical form and returns its length, or 0 if s does not start with a
+// complete tag (it is then hashed as text). Names are expanded with the
+// namespace scopes of the open elements, unless scopes is nil.
+func (h *semanticHasher) tag(s string, scopes *[]namespaceScope) int {
+	i := 1
+	closing := s[i] == '/'
+	if closing {
+		i++
+	}
+	nameStart := i
+	i = skipName(s, i)
+	name := s[nameStart:i]
+	if name == "" {
+		return 0
+	}
+
+	// Small tags keep their attributes on the stack
+	var backing [8]attribute
+	attrs := backing[:0]
+	empty := false
+	for {
+		i = skipSpace(s, i)
+		if i >= len(s) {
+			return 0
+		}
+		if s[i] == '>' {
+			i++
+			break
+		}
+		if s[i] == '/' {
+			if i+1 < len(s) && s[i+1] == '>' {
+				empty = true
+				i += 2
+				break
+			}
+			return 0
+		}
+		if closing {
+			return 0
+		}
+
+		attrStart := i
+		i = skipName(s, i)
+		if i == attrStart {
+			return 0
+		}
+		attr := attribute{name: s[attrStart:i]}
+		j := skipSpace(s, i)
+		if j < len(s) && s[j] == '=' {
+			j = skipSpace(s, j+1)
+			if j >= len(s) {
+				return 0
+			}
+			if q := s[j]; q == '"' || q == '\'' {
+				end := strings.IndexByte(s[j+1:], q)
+				if end < 0 {
+					return 0
+				}
+				attr.value = s[j+1 : j+1+end]
+				i = j + end + 2
+			} else {
+				// Unquoted attribute value - normalize by adding quotes
+				valueStart := j
+				for j < len(s) && s[j] > WHITESPACE_THRESHOLD && s[j] != '>' && s[j] != '/' {
+					j++
+				}
+				attr.value = s[valueStart:j]
+				i = j
+			}
+		}
+
+		// Insertion sort keeps attributes with the same name in input order
+		attrs = append(attrs, attr)
+		for k := len(attrs) - 1; k > 0 && attrs[k].name < attrs[k-1].name; k-- {
+			attrs[k], attrs[k-1] = attrs[k-1], attrs[k]
+		}
+	}
+
+	if scopes != nil {
+		name, attrs = expandNames(scopes, name, attrs, closing, empty)
+	}
+
+	h.writeByte('<')
+	if closing {
+		h.writeByte('/')
+	}
+	h.writeString(name)
+	for _, attr := range attrs {
+		h.writeByte(' ')
+		h.writeString(attr.name)
+		h.writeString(`="`)
+		for v := attr.value; len(v) > 0; {
+			if v[0] == '&' {
+				v = v[h.reference(v):]
+				continue
+			}
+			h.writeByte(v[0])
+			v = v[1:]
+		}
+		h.writeByte('"')
+	}
+	// Whitespace before the end of the tag does not count: <a/> and <a />
+	// are the same element
+	if empty {
+		h.writeByte('/')
+	}
+	h.writeByte('>')
+	return i
+}
+
+// expandNames returns the expanded names of a tag and follows the scopes of
+// the elements it opens and closes. A namespace declaration that changes
+// nothing in scope is dropped; the others are kept by namespace alone, so
+// the prefix a document picks does not matter.
+func expandNames(scopes *[]namespaceScope, name string, attrs []attribute, closing, empty bool) (string, []attribute) {
+	stack := *scopes
+	top := stack[len(stack)-1]
+	if closing {
+		if len(stack) > 1 {
+			*scopes = stack[:len(stack)-1]
+		}
+		return top.expand(name, true), attrs
+	}
+
+	scope := top
+	copied := false
+	kept := attrs[:0]
+	for _, a := range attrs {
+		prefix, ok := declaredPrefix(a.name)
+		if !ok {
+			kept = append(kept, a)
+			continue
+		}
+		if uri, bound := top[prefix]; bound && uri == a.value || !bound && prefix == "" && a.value == "" {
+			continue
+		}
+		if !copied {
+			scope, copied = top.copy(), true
+		}
+		scope[prefix] = a.value
+		kept = append(kept, attribute{name: "xmlns", value: a.value})
+	}
+	for i := range kept {
+		if kept[i].name != "xmlns" {
+			kept[i].name = scope.expand(kept[i].name, false)
+		}
+		for k := i; k > 0 && (kept[k].name < kept[k-1].name || kept[k].name == kept[k-1].name && kept[k].value < kept[k-1].value); k-- {
+			kept[k], kept[k-1] = kept[k-1], kept[k]
+		}
+	}
+	if !empty {
+		*scopes = append(stack, scope)
+	}
+	return scope.expand(name, true), kept
+}
+
+// reference hashes the character or entity reference at the start of s as
+// the character it stands for and returns its length. An unknown or
+// malformed reference is hashed as a literal '&'.
+func (h *semanticHasher) reference(s string) int {
+	end := strings.IndexByte(s, ';')
+	if end < 0 || end > MAX_REFERENCE_LENGTH {
+		h.writeByte('&')
+		return 1
+	}
+
+	if r, ok := decodeReference(s[1:end]); ok {
+		var buf [utf8.UTFMax]byte
+		n := utf8.EncodeRune(buf[:], r)
+		for _, b := range buf[:n] {
+			h.writeByte(b)
+		}
+		return end + 1
+	}
+	h.writeByte('&')
+	return 1
+}
+
+// decodeReference resolves the body of a reference such as "amp" or "#x26"
+func decodeReference(ref string) (rune, bool) {
+	switch ref {
+	case "amp":
+		return '&', true
+	case "lt":
+		return '<', true
+	case "gt":
+		return '>', true
+	case "quot":
+		return '"', true
+	case "apos":
+		return '\'', true
+	}
+
+	if len(ref) < 2 || ref[0] != '#' {
+		return 0, false
+	}
+	base, digits := 10, ref[1:]
+	if digits[0] == 'x' || digits[0] == 'X' {
+		base, digits = 16, digits[1:]
+	}
+	code, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return 0, false
+	}
+	return rune(code), true
+}
+
+func skipName(s string, i int) int {
+	for i < len(s) && s[i] > WHITESPACE_THRESHOLD && s[i] != '=' && s[i] != '/' && s[i] != '>' && s[i] != '<' {
+		i++
+	}
+	return i
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && s[i] <= WHITESPACE_THRESHOLD {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,104 @@
+package fixml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIndentation(t *testing.T) {
+	input := XML_DECLARATION + `<Project>
+<ItemGroup>
+<Compile Include="a.cs"
+Link="b.cs"
+/>
+<Item Name="x"
+      Value="y">text</Item>
+</ItemGroup>
+</Project>
+`
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{}, `<Project>
+  <ItemGroup>
+    <Compile Include="a.cs"
+      Link="b.cs"
+      />
+    <Item Name="x"
+      Value="y">text</Item>
+  </ItemGroup>
+</Project>
+`},
+		{"four spaces", Options{IndentWidth: 4}, `<Project>
+    <ItemGroup>
+        <Compile Include="a.cs"
+            Link="b.cs"
+            />
+        <Item Name="x"
+            Value="y">text</Item>
+    </ItemGroup>
+</Project>
+`},
+		{"tabs", Options{IndentTabs: true, IndentWidth: 4}, "<Project>\n\t<ItemGroup>\n\t\t<Compile Include=\"a.cs\"\n\t\t\tLink=\"b.cs\"\n\t\t\t/>\n\t\t<Item Name=\"x\"\n\t\t\tValue=\"y\">text</Item>\n\t</ItemGroup>\n</Project>\n"},
+		{"attributes two levels in", Options{AttributeIndent: 2}, `<Project>
+  <ItemGroup>
+    <Compile Include="a.cs"
+        Link="b.cs"
+        />
+    <Item Name="x"
+        Value="y">text</Item>
+  </ItemGroup>
+</Project>
+`},
+		{"attributes aligned", Options{IndentWidth: 4, AttributeIndent: ATTRIBUTE_INDENT_ALIGN}, `<Project>
+    <ItemGroup>
+        <Compile Include="a.cs"
+                 Link="b.cs"
+                 />
+        <Item Name="x"
+              Value="y">text</Item>
+    </ItemGroup>
+</Project>
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, err := Process(strings.NewReader(input), &out, tt.opts); err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if want := XML_DECLARATION + tt.want; out.String() != want {
+				t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
+
+// Nesting has no fixed limit, whatever the width of a level
+func TestIndentationDepth(t *testing.T) {
+	const depth = 300
+	var input strings.Builder
+	input.WriteString(XML_DECLARATION)
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&input, "<e%d>\n", i)
+	}
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&input, "</e%d>\n", i)
+	}
+
+	var out bytes.Buffer
+	if _, err := Process(strings.NewReader(input.String()), &out, Options{IndentWidth: 3}); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	for _, i := range []int{0, 1, depth / 2, depth - 1} {
+		want := strings.Repeat(" ", 3*i) + fmt.Sprintf("<e%d>", i)
+		if got := lines[1+i]; got != want {
+			t.Errorf("line of <e%d> = %q, want %d spaces", i, got, 3*i)
+		}
+	}
+}
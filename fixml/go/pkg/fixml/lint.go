@@ -0,0 +1,292 @@
+package fixml
+
+import (
+	"fmt"
+	"sync"
+)
+
+const MAX_WARNINGS_PER_RULE = 100 // Findings of one rule kept in Result.Warnings; the rest are only counted
+
+// Severity ranks the findings of a rule.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityError:
+		return "error"
+	}
+	return "warning"
+}
+
+// RuleInfo describes a rule.
+type RuleInfo struct {
+	ID          string // Used to enable or disable the rule in Options.Warnings
+	Category    string // Short tag shown in brackets, e.g. "XML"
+	Severity    Severity
+	Description string
+	Fix         string // Human-readable remedy
+	Fixed       string // What an applied fix did, for Result.Fixes; empty if the rule cannot fix
+	Optional    bool   // Runs only when enabled in Options.Warnings
+}
+
+// Token is the view of one markup construct that rules inspect.
+type Token struct {
+	Kind  TokenKind
+	Raw   string // Exact text, including any fix applied by an earlier rule
+	Name  string // Element name for tags, target for processing instructions
+	Line  int    // 1-based position of the first character
+	Col   int
+	Depth int // Open elements around the token
+
+	attrs []tagAttribute // Attributes of a start or empty tag, scanned once for all rules
+}
+
+// attributes returns the attributes of a start or empty tag
+func (t Token) attributes() []tagAttribute {
+	if t.attrs == nil {
+		return scanAttributes(t.Raw)
+	}
+	return t.attrs
+}
+
+// Document describes the document being checked.
+type Document struct {
+	HasXMLDeclaration bool
+	Encoding          Encoding // Encoding the output is written in
+}
+
+// Finding is a problem a rule found.
+type Finding struct {
+	Message string
+	Line    int    // 0 selects the position of the token, or no position for CheckDocument
+	Col     int    //
+	Remedy  string // Replaces RuleInfo.Fix when set
+	Fix     *Fix   // nil when the problem cannot be fixed automatically
+}
+
+// Fix is the correction of a finding, applied with Options.FixWarnings.
+type Fix struct {
+	// Raw replaces the text of the token. For CheckDocument findings it is
+	// inserted at the start of the output.
+	Raw string
+	// MergeNext makes Raw replace the following token as well; a start
+	// tag merged with its end tag becomes an empty-element tag.
+	MergeNext bool
+}
+
+// Rule checks one XML best practice. Rules are shared by concurrent runs and
+// must not keep state between calls.
+type Rule interface {
+	Info() RuleInfo
+	// CheckDocument is called once per document before any token.
+	CheckDocument(doc Document) []Finding
+	// Check is called for every token; next is the following token, or nil
+	// at the end of the input.
+	Check(doc Document, tok Token, next *Token) []Finding
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   []Rule
+)
+
+// RegisterRule adds a rule to the registry. Rules run in registration order,
+// each seeing the fixes of the rules before it. Registering an ID twice panics.
+func RegisterRule(r Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	id := r.Info().ID
+	for _, existing := range rules {
+		if existing.Info().ID == id {
+			panic(fmt.Sprintf("fixml: rule %q registered twice", id))
+		}
+	}
+	rules = append(rules, r)
+}
+
+// Rules returns the registered rules in registration order.
+func Rules() []Rule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return append([]Rule(nil), rules...)
+}
+
+// LookupRule returns the registered rule with the given ID.
+func LookupRule(id string) (Rule, bool) {
+	for _, r := range Rules() {
+		if r.Info().ID == id {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// linter runs the enabled rules over the token stream, records findings in
+// the Result and applies fixes when Options.FixWarnings is set
+type linter struct {
+	opts  Options
+	doc   Document
+	res   *Result
+	rules []Rule
+	infos []RuleInfo
+	fixes []int // Fixes applied per rule
+	depth int
+	next  Token // View of the token after the current one, reused to save allocations
+
+	prefixes *prefixTracker // Follows namespace declarations; nil unless unused-namespace-prefix runs
+}
+
+func newLinter(opts Options, doc Document, res *Result) *linter {
+	l := &linter{opts: opts, doc: doc, res: res}
+	for _, r := range Rules() {
+		info := r.Info()
+		if opts.ruleEnabled(info) {
+			l.rules = append(l.rules, r)
+			l.infos = append(l.infos, info)
+			if info.ID == WARNING_UNUSED_PREFIX {
+				l.prefixes = &prefixTracker{}
+			}
+		}
+	}
+	l.fixes = make([]int, len(l.rules))
+	return l
+}
+
+// start runs the document checks and returns the text fixes insert at the
+// start of the output
+func (l *linter) start() string {
+	prefix := ""
+	for i, r := range l.rules {
+		for _, finding := range r.CheckDocument(l.doc) {
+			l.record(i, finding)
+			if finding.Fix != nil && l.opts.FixWarnings {
+				prefix += finding.Fix.Raw
+				l.fixes[i]++
+			}
+		}
+	}
+	return prefix
+}
+
+// token checks tok and returns it with the applied fixes. merged reports
+// that a fix replaced next as well, so next must not be formatted.
+func (l *linter) token(tok token, next *token) (token, bool) {
+	if len(l.rules) == 0 {
+		return tok, false
+	}
+
+	depth := l.depth
+	view := Token{Kind: tok.kind, Raw: tok.raw, Name: tok.name, Line: tok.line, Col: tok.col, Depth: depth}
+	view.scan()
+	var nextView *Token
+	if next != nil {
+		nextDepth := depth
+		if tok.kind == TokenStartTag {
+			nextDepth++
+		}
+		l.next = Token{Kind: next.kind, Raw: next.raw, Name: next.name, Line: next.line, Col: next.col, Depth: nextDepth}
+		nextView = &l.next
+	}
+
+	merged := false
+	for i, r := range l.rules {
+		for _, finding := range r.Check(l.doc, view, nextView) {
+			if finding.Line == 0 {
+				finding.Line, finding.Col = view.Line, view.Col
+			}
+			l.record(i, finding)
+			if finding.Fix == nil || !l.opts.FixWarnings || (finding.Fix.MergeNext && (merged || nextView == nil)) {
+				continue
+			}
+			view.Raw = finding.Fix.Raw
+			if finding.Fix.MergeNext {
+				merged = true
+				if view.Kind == TokenStartTag {
+					view.Kind = TokenEmptyTag
+				}
+			}
+			view.scan()
+			l.fixes[i]++
+		}
+	}
+
+	switch {
+	case tok.kind == TokenStartTag && !merged:
+		l.depth++
+	case tok.kind == TokenEndTag && l.depth > 0:
+		l.depth--
+	}
+
+	tok.raw, tok.kind = view.Raw, view.Kind
+	if l.prefixes != nil {
+		for _, finding := range l.prefixes.token(tok, view.attrs) {
+			recordWarning(l.res, unusedPrefixRule{}.Info(), finding)
+		}
+	}
+	return tok, merged
+}
+
+// scan caches the attributes of a start or empty tag for the rules
+func (t *Token) scan() {
+	t.attrs = nil
+	if t.Kind == TokenStartTag || t.Kind == TokenEmptyTag {
+		t.attrs = scanAttributes(t.Raw)
+	}
+}
+
+// record adds a finding of the i-th enabled rule to the Result
+func (l *linter) record(i int, finding Finding) {
+	recordWarning(l.res, l.infos[i], finding)
+}
+
+// recordWarning counts a finding of a rule and lists it in res.Warnings,
+// unless the rule already has MAX_WARNINGS_PER_RULE listed
+func recordWarning(res *Result, info RuleInfo, finding Finding) {
+	if res.RuleCounts == nil {
+		res.RuleCounts = make(map[string]int)
+	}
+	res.RuleCounts[info.ID]++
+	if res.RuleCounts[info.ID] > MAX_WARNINGS_PER_RULE {
+		return
+	}
+
+	remedy := finding.Remedy
+	if remedy == "" {
+		remedy = info.Fix
+	}
+	res.Warnings = append(res.Warnings, Warning{
+		ID:       info.ID,
+		Category: info.Category,
+		Severity: info.Severity.String(),
+		Message:  finding.Message,
+		Fix:      remedy,
+		Line:     finding.Line,
+		Col:      finding.Col,
+	})
+}
+
+// finish reports what is left of the element stack and lists the applied
+// fixes in the Result, one entry per rule
+func (l *linter) finish() {
+	if l.prefixes != nil {
+		for _, finding := range l.prefixes.finish() {
+			recordWarning(l.res, unusedPrefixRule{}.Info(), finding)
+		}
+	}
+	for i, n := range l.fixes {
+		switch {
+		case n == 1:
+			l.res.Fixes = append(l.res.Fixes, l.infos[i].Fixed)
+		case n > 1:
+			l.res.Fixes = append(l.res.Fixes, fmt.Sprintf("%s (%d times)", l.infos[i].Fixed, n))
+		}
+	}
+}
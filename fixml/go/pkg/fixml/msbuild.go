@@ -0,0 +1,410 @@
+package fixml
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile selects processing specific to a kind of XML document on top of
+// the generic formatting.
+type Profile int
+
+const (
+	// ProfileNone treats every document as generic XML.
+	ProfileNone Profile = iota
+	// ProfileMSBuild treats the document as an MSBuild project: PackageReference
+	// items are deduplicated by package ID, and conflicting versions are
+	// reported or resolved according to Options.VersionPolicy.
+	ProfileMSBuild
+)
+
+// VersionPolicy selects what the MSBuild profile does when one package is
+// referenced with different versions.
+type VersionPolicy int
+
+const (
+	// VersionReport keeps every version and reports the conflict.
+	VersionReport VersionPolicy = iota
+	// VersionHighest keeps the reference with the highest version.
+	VersionHighest
+	// VersionLowest keeps the reference with the lowest version.
+	VersionLowest
+	// VersionFirst keeps the reference that comes first in the document.
+	VersionFirst
+)
+
+func (p VersionPolicy) String() string {
+	switch p {
+	case VersionHighest:
+		return "highest"
+	case VersionLowest:
+		return "lowest"
+	case VersionFirst:
+		return "first"
+	}
+	return "report"
+}
+
+// WARNING_PACKAGE_CONFLICT is the rule ID of PackageReference version conflicts
+const WARNING_PACKAGE_CONFLICT = "package-version-conflict"
+
+// PACKAGE_KEY_ATTRIBUTES are the attributes naming the package of a
+// PackageReference; Include adds a reference, Update changes an existing one
+var PACKAGE_KEY_ATTRIBUTES = []string{"Include", "Update"}
+
+func init() {
+	RegisterRule(packageConflictRule{})
+}
+
+// packageConflictRule only describes the conflicts found by resolvePackages,
+// which needs the whole document; it never reports from the token stream
+type packageConflictRule struct {
+	tokenRule
+	documentRule
+}
+
+func (packageConflictRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_PACKAGE_CONFLICT,
+		Category:    "MSBuild",
+		Severity:    SeverityError,
+		Description: "A package is referenced with different versions (MSBuild profile)",
+		Fix:         "Reference each package with a single version",
+	}
+}
+
+// packageReference is a PackageReference item found by the MSBuild profile
+type packageReference struct {
+	node     *node
+	id       string
+	version  string // From the Version attribute or a <Version> child; "" if none
+	metadata string // Every other attribute and child element, normalized
+	line     int
+}
+
+// resolvePackages applies the MSBuild profile to the cleaned document
+// content. References are grouped by their Include or Update value (case
+// insensitive, as NuGet package IDs are) and the Condition attributes of the
+// reference and its ancestors, so references for different target frameworks
+// never collide. Within a group, a repeated version is removed, and
+// conflicting versions are reported and resolved by opts.VersionPolicy.
+// Removed references are replaced by their line breaks, so the formatter
+// drops the empty lines and later line numbers still match the input.
+// Every token read is also passed to v, if not nil.
+func resolvePackages(content []byte, opts Options, v *validator, res *Result) []byte {
+	root := parseNodes(content, v)
+
+	groups := make(map[string][]*packageReference)
+	var keys []string
+	var walk func(n *node, scope string)
+	walk = func(n *node, scope string) {
+		for _, child := range n.children {
+			if child.tok.kind != TokenStartTag && child.tok.kind != TokenEmptyTag {
+				continue
+			}
+			attrs := attributeValues(child.tok.raw)
+			childScope := scope
+			if condition, ok := attrs["Condition"]; ok {
+				childScope += "\x00" + strings.Join(strings.Fields(condition), " ")
+			}
+			if child.tok.name != "PackageReference" {
+				walk(child, childScope)
+				continue
+			}
+
+			for _, attr := range PACKAGE_KEY_ATTRIBUTES {
+				id, ok := attrs[attr]
+				if !ok {
+					continue
+				}
+				id = strings.TrimSpace(id)
+				key := attr + "\x00" + strings.ToLower(id) + childScope
+				if _, seen := groups[key]; !seen {
+					keys = append(keys, key)
+				}
+				groups[key] = append(groups[key], &packageReference{
+					node:     child,
+					id:       id,
+					version:  packageVersion(child, attrs),
+					metadata: packageMetadata(child, attrs),
+					line:     child.tok.line,
+				})
+				break
+			}
+		}
+	}
+	walk(root, "")
+
+	removed := make(map[*node]bool)
+	remove := func(ref, kept *packageReference) {
+		removed[ref.node] = true
+		res.DuplicatesRemoved++
+		// Verify tells the references removed here from those the formatter removes
+		if opts.ReportDuplicates || opts.Verify {
+			res.Duplicates = append(res.Duplicates, Duplicate{Line: ref.line, Text: strings.TrimSpace(nodeText(ref.node)), DuplicateOf: kept.line})
+		}
+	}
+
+	info := packageConflictRule{}.Info()
+	for _, key := range keys {
+		// The first reference of each version is kept, later ones repeat it.
+		// A repetition with other metadata is kept and reported instead, as
+		// removing it would lose settings such as PrivateAssets.
+		var versions []*packageReference
+		for _, ref := range groups[key] {
+			first := findVersion(versions, ref.version)
+			switch {
+			case first == nil:
+				versions = append(versions, ref)
+			case first.metadata == ref.metadata:
+				remove(ref, first)
+			case opts.ruleEnabled(info):
+				recordWarning(res, info, Finding{
+					Message: fmt.Sprintf("Package %q is referenced again with different metadata (first at line %d)", ref.id, first.line),
+					Line:    ref.line,
+					Remedy:  "Merge the references into one",
+				})
+			}
+		}
+		if len(versions) < 2 {
+			continue
+		}
+
+		listed := make([]string, len(versions))
+		for i, ref := range versions {
+			listed[i] = fmt.Sprintf("%s (line %d)", versionName(ref.version), ref.line)
+		}
+		finding := Finding{
+			Message: fmt.Sprintf("Package %q is referenced with versions %s", versions[0].id, strings.Join(listed, ", ")),
+			Line:    versions[1].line,
+		}
+
+		winner, ok := chooseVersion(versions, opts.VersionPolicy)
+		switch {
+		case opts.VersionPolicy == VersionReport:
+		case !ok:
+			finding.Remedy = "The versions cannot be compared; reference each package with a single version"
+		default:
+			for _, ref := range versions {
+				if ref != winner {
+					remove(ref, winner)
+				}
+			}
+			finding.Remedy = fmt.Sprintf("Resolved by keeping version %s (%s)", versionName(winner.version), opts.VersionPolicy)
+			res.Fixes = append(res.Fixes, fmt.Sprintf("Kept %s %s (%s of %d versions)", winner.id, versionName(winner.version), opts.VersionPolicy, len(versions)))
+		}
+		if opts.ruleEnabled(info) {
+			recordWarning(res, info, finding)
+		}
+	}
+
+	if len(removed) == 0 {
+		return content
+	}
+	var out bytes.Buffer
+	out.Grow(len(content))
+	for _, child := range root.children {
+		writeRemaining(&out, child, removed)
+	}
+	return out.Bytes()
+}
+
+// packageVersion returns the version of a PackageReference, given as an
+// attribute or as a <Version> child element
+func packageVersion(n *node, attrs map[string]string) string {
+	if version, ok := attrs["Version"]; ok {
+		return strings.TrimSpace(version)
+	}
+	for _, child := range n.children {
+		if child.tok.kind == TokenStartTag && child.tok.name == "Version" {
+			var text strings.Builder
+			for _, content := range child.children {
+				if content.tok.kind == TokenText {
+					text.WriteString(content.tok.raw)
+				}
+			}
+			return strings.TrimSpace(text.String())
+		}
+	}
+	return ""
+}
+
+// packageMetadata describes everything but the package and version of a
+// PackageReference, so references that only repeat them can be told apart
+func packageMetadata(n *node, attrs map[string]string) string {
+	var parts []string
+	for name, value := range attrs {
+		switch name {
+		case "Include", "Update", "Version", "Condition":
+		default:
+			parts = append(parts, name+"="+value)
+		}
+	}
+	sort.Strings(parts)
+	for _, child := range n.children {
+		if (child.tok.kind == TokenStartTag || child.tok.kind == TokenEmptyTag) && child.tok.name != "Version" {
+			parts = append(parts, strings.Join(strings.Fields(nodeText(child)), " "))
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func versionName(version string) string {
+	if version == "" {
+		return "(none)"
+	}
+	return version
+}
+
+// findVersion returns the reference among refs with the same version
+func findVersion(refs []*packageReference, version string) *packageReference {
+	for _, ref := range refs {
+		if ref.version == version {
+			return ref
+		}
+		a, okA := parseVersion(ref.version)
+		b, okB := parseVersion(version)
+		if okA && okB && a.compare(b) == 0 {
+			return ref
+		}
+	}
+	return nil
+}
+
+// chooseVersion picks the reference to keep under policy. It reports false
+// when the policy needs to compare versions that cannot be parsed, such as
+// ranges, floating versions or MSBuild properties.
+func chooseVersion(refs []*packageReference, policy VersionPolicy) (*packageReference, bool) {
+	switch policy {
+	case VersionFirst:
+		return refs[0], true
+	case VersionHighest, VersionLowest:
+	default:
+		return nil, false
+	}
+
+	winner := refs[0]
+	best, ok := parseVersion(winner.version)
+	if !ok {
+		return nil, false
+	}
+	for _, ref := range refs[1:] {
+		v, ok := parseVersion(ref.version)
+		if !ok {
+			return nil, false
+		}
+		c := v.compare(best)
+		if policy == VersionLowest {
+			c = -c
+		}
+		if c > 0 {
+			winner, best = ref, v
+		}
+	}
+	return winner, true
+}
+
+// nugetVersion is a parsed NuGet package version: up to four numeric parts
+// and optional prerelease labels
+type nugetVersion struct {
+	parts      [4]int
+	prerelease []string // Empty for a release version
+}
+
+// parseVersion parses a plain NuGet version such as 1.2, 6.0.0-rc.1 or
+// 1.0.0+build. Ranges, floating versions and properties are rejected.
+func parseVersion(s string) (nugetVersion, bool) {
+	var v nugetVersion
+	s, _, _ = strings.Cut(s, "+")
+	core, pre, hasPre := strings.Cut(s, "-")
+	fields := strings.Split(core, ".")
+	if len(fields) > len(v.parts) {
+		return v, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.parts[i] = n
+	}
+	if hasPre {
+		if pre == "" {
+			return v, false
+		}
+		v.prerelease = strings.Split(pre, ".")
+	}
+	return v, true
+}
+
+// compare orders versions by SemVer 2.0 precedence; a prerelease sorts
+// before its release, and numeric labels before alphanumeric ones
+func (v nugetVersion) compare(other nugetVersion) int {
+	for i := range v.parts {
+		if c := cmp.Compare(v.parts[i], other.parts[i]); c != 0 {
+			return c
+		}
+	}
+	if len(v.prerelease) == 0 || len(other.prerelease) == 0 {
+		return cmp.Compare(len(other.prerelease), len(v.prerelease))
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = cmp.Compare(na, nb)
+		case errA == nil:
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.prerelease), len(other.prerelease))
+}
+
+// attributeValues returns the attributes of a start or empty tag by name
+func attributeValues(raw string) map[string]string {
+	attrs := scanAttributes(raw)
+	values := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		if a.eq >= 0 {
+			values[a.name] = a.value(raw)
+		}
+	}
+	return values
+}
+
+// nodeText returns the text of a node and its subtree as in the input
+func nodeText(n *node) string {
+	var b bytes.Buffer
+	writeRemaining(&b, n, nil)
+	return b.String()
+}
+
+// writeRemaining writes a node and its subtree; removed nodes leave only
+// their line breaks behind
+func writeRemaining(out *bytes.Buffer, n *node, removed map[*node]bool) {
+	if removed[n] {
+		out.WriteString(strings.Repeat("\n", strings.Count(nodeText(n), "\n")))
+		return
+	}
+	out.WriteString(n.tok.raw)
+	for _, child := range n.children {
+		writeRemaining(out, child, removed)
+	}
+	if n.end != nil {
+		out.WriteString(n.end.raw)
+	}
+}
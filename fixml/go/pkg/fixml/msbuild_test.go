@@ -0,0 +1,182 @@
+package fixml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// project wraps item groups in a Project element, one item per line
+func project(groups ...string) string {
+	return "<Project Sdk=\"Microsoft.NET.Sdk\">\n" + strings.Join(groups, "\n") + "\n</Project>\n"
+}
+
+// packageWarnings returns the package conflicts reported in res
+func packageWarnings(res Result) []Warning {
+	var found []Warning
+	for _, w := range res.Warnings {
+		if w.ID == WARNING_PACKAGE_CONFLICT {
+			found = append(found, w)
+		}
+	}
+	return found
+}
+
+func TestMSBuildVersionPolicies(t *testing.T) {
+	input := project(`<ItemGroup>
+<PackageReference Include="Serilog" Version="2.10.0" />
+<PackageReference Include="Newtonsoft.Json" Version="13.0.1" />
+<PackageReference Include="serilog" Version="3.1.1" />
+<PackageReference Include="Serilog">
+<Version>2.12.0</Version>
+</PackageReference>
+</ItemGroup>`)
+	conflict := `Package "Serilog" is referenced with versions 2.10.0 (line 3), 3.1.1 (line 5), 2.12.0 (line 6)`
+
+	tests := []struct {
+		policy VersionPolicy
+		kept   string // The Serilog reference left in the output; "" keeps all three
+		fix    string
+		remedy string
+	}{
+		{VersionReport, "", "", "Reference each package with a single version"},
+		{VersionHighest, `<PackageReference Include="serilog" Version="3.1.1" />`, "Kept serilog 3.1.1 (highest of 3 versions)", "Resolved by keeping version 3.1.1 (highest)"},
+		{VersionLowest, `<PackageReference Include="Serilog" Version="2.10.0" />`, "Kept Serilog 2.10.0 (lowest of 3 versions)", "Resolved by keeping version 2.10.0 (lowest)"},
+		{VersionFirst, `<PackageReference Include="Serilog" Version="2.10.0" />`, "Kept Serilog 2.10.0 (first of 3 versions)", "Resolved by keeping version 2.10.0 (first)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			var out bytes.Buffer
+			res, err := Process(strings.NewReader(input), &out, Options{Profile: ProfileMSBuild, VersionPolicy: tt.policy, ReportDuplicates: true})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			warnings := packageWarnings(res)
+			if len(warnings) != 1 || warnings[0].Message != conflict || warnings[0].Line != 5 || warnings[0].Fix != tt.remedy {
+				t.Errorf("warnings = %+v, want %q on line 5 with remedy %q", warnings, conflict, tt.remedy)
+			}
+			if !strings.Contains(out.String(), `<PackageReference Include="Newtonsoft.Json" Version="13.0.1" />`) {
+				t.Errorf("output lost an unrelated package:\n%s", out.String())
+			}
+
+			if tt.kept == "" {
+				if res.DuplicatesRemoved != 0 || len(res.Fixes) != 0 {
+					t.Errorf("report removed %d references, fixes %q", res.DuplicatesRemoved, res.Fixes)
+				}
+				if n := strings.Count(strings.ToLower(out.String()), `include="serilog"`); n != 3 {
+					t.Errorf("output keeps %d Serilog references, want 3:\n%s", n, out.String())
+				}
+				return
+			}
+			if n := strings.Count(strings.ToLower(out.String()), `include="serilog"`); n != 1 || !strings.Contains(out.String(), tt.kept) {
+				t.Errorf("output keeps %d Serilog references, want only %s:\n%s", n, tt.kept, out.String())
+			}
+			if res.DuplicatesRemoved != 2 || len(res.Duplicates) != 2 {
+				t.Errorf("DuplicatesRemoved = %d, Duplicates = %+v, want 2", res.DuplicatesRemoved, res.Duplicates)
+			}
+			if len(res.Fixes) != 1 || res.Fixes[0] != tt.fix {
+				t.Errorf("Fixes = %q, want %q", res.Fixes, tt.fix)
+			}
+		})
+	}
+}
+
+func TestMSBuildConditionalItemGroups(t *testing.T) {
+	input := project(`<ItemGroup Condition="'$(TargetFramework)' == 'net6.0'">
+<PackageReference Include="System.Text.Json" Version="6.0.0" />
+</ItemGroup>`, `<ItemGroup Condition="'$(TargetFramework)' == 'net8.0'">
+<PackageReference Include="System.Text.Json" Version="8.0.0" />
+</ItemGroup>`, `<ItemGroup Condition="'$(TargetFramework)'   ==   'net8.0'">
+<PackageReference Include="System.Text.Json" Version="8.0.4" />
+</ItemGroup>`)
+
+	var out bytes.Buffer
+	res, err := Process(strings.NewReader(input), &out, Options{Profile: ProfileMSBuild, VersionPolicy: VersionHighest})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	// Conditions equal up to whitespace share a scope, the others do not
+	for _, version := range []string{`"6.0.0"`, `"8.0.4"`} {
+		if !strings.Contains(out.String(), version) {
+			t.Errorf("output lost version %s:\n%s", version, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `"8.0.0"`) {
+		t.Errorf("output keeps 8.0.0 next to 8.0.4 under the same condition:\n%s", out.String())
+	}
+	want := `Package "System.Text.Json" is referenced with versions 8.0.0 (line 6), 8.0.4 (line 9)`
+	if warnings := packageWarnings(res); len(warnings) != 1 || warnings[0].Message != want {
+		t.Errorf("warnings = %+v, want only %q", warnings, want)
+	}
+}
+
+func TestMSBuildRepeatedReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   string
+		removed int
+		warning string // Message of the only package warning; "" for none
+		line    int
+	}{
+		{
+			"same version is removed",
+			"<PackageReference Include=\"Dapper\" Version=\"2.1.0\" />\n<PackageReference Include=\"dapper\" Version=\"2.1\" />",
+			1, "", 0,
+		},
+		{
+			"attribute and child element forms match",
+			"<PackageReference Include=\"Dapper\" Version=\"2.1.0\" />\n<PackageReference Include=\"Dapper\">\n<Version>2.1.0</Version>\n</PackageReference>",
+			1, "", 0,
+		},
+		{
+			"different metadata is kept and reported",
+			"<PackageReference Include=\"Dapper\" Version=\"2.1.0\" />\n<PackageReference Include=\"Dapper\" Version=\"2.1.0\" PrivateAssets=\"all\" />",
+			0, `Package "Dapper" is referenced again with different metadata (first at line 3)`, 4,
+		},
+		{
+			"Update does not collide with Include",
+			"<PackageReference Include=\"Dapper\" Version=\"2.1.0\" />\n<PackageReference Update=\"Dapper\" Version=\"2.0.0\" />",
+			0, "", 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			res, err := Process(strings.NewReader(project("<ItemGroup>\n"+tt.items+"\n</ItemGroup>")), &out, Options{Profile: ProfileMSBuild, VersionPolicy: VersionHighest})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if res.DuplicatesRemoved != tt.removed {
+				t.Errorf("DuplicatesRemoved = %d, want %d:\n%s", res.DuplicatesRemoved, tt.removed, out.String())
+			}
+			warnings := packageWarnings(res)
+			switch {
+			case tt.warning == "" && len(warnings) > 0:
+				t.Errorf("unexpected warnings: %+v", warnings)
+			case tt.warning != "" && (len(warnings) != 1 || warnings[0].Message != tt.warning || warnings[0].Line != tt.line):
+				t.Errorf("warnings = %+v, want %q on line %d", warnings, tt.warning, tt.line)
+			}
+		})
+	}
+}
+
+func TestMSBuildIncomparableVersions(t *testing.T) {
+	input := project(`<ItemGroup>
+<PackageReference Include="Polly" Version="7.2.4" />
+<PackageReference Include="Polly" Version="$(PollyVersion)" />
+</ItemGroup>`)
+
+	var out bytes.Buffer
+	res, err := Process(strings.NewReader(input), &out, Options{Profile: ProfileMSBuild, VersionPolicy: VersionHighest})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if res.DuplicatesRemoved != 0 || len(res.Fixes) != 0 {
+		t.Errorf("removed %d references, fixes %q; want both kept", res.DuplicatesRemoved, res.Fixes)
+	}
+	warnings := packageWarnings(res)
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0].Fix, "The versions cannot be compared") {
+		t.Errorf("warnings = %+v, want the versions reported as not comparable", warnings)
+	}
+}
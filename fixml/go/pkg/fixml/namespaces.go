@@ -0,0 +1,478 @@
+package fixml
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rule IDs of the namespace rules
+const (
+	WARNING_UNUSED_PREFIX      = "unused-namespace-prefix"
+	WARNING_REPEATED_NAMESPACE = "repeated-namespace"
+)
+
+func init() {
+	RegisterRule(unusedPrefixRule{})
+	RegisterRule(repeatedNamespaceRule{})
+}
+
+// namespaceScope maps the prefixes in scope at an element to their
+// namespace URIs; "" is the default namespace. Scopes are shared between
+// elements and copied when a tag declares something new.
+type namespaceScope map[string]string
+
+// declaredPrefix reports whether an attribute is a namespace declaration and
+// returns the prefix it binds, "" for xmlns
+func declaredPrefix(attr string) (string, bool) {
+	if attr == "xmlns" {
+		return "", true
+	}
+	if strings.HasPrefix(attr, "xmlns:") {
+		return attr[len("xmlns:"):], true
+	}
+	return "", false
+}
+
+// declare returns the scope inside the element started by raw: s with the
+// tag's namespace declarations added, or s itself if it has none
+func (s namespaceScope) declare(raw string) namespaceScope {
+	if !strings.Contains(raw, "xmlns") {
+		return s
+	}
+	scope := s
+	copied := false
+	for _, a := range scanAttributes(raw) {
+		prefix, ok := declaredPrefix(a.name)
+		if !ok || a.eq < 0 {
+			continue
+		}
+		if !copied {
+			scope, copied = s.copy(), true
+		}
+		scope[prefix] = a.value(raw)
+	}
+	return scope
+}
+
+func (s namespaceScope) copy() namespaceScope {
+	scope := make(namespaceScope, len(s)+1)
+	for prefix, uri := range s {
+		scope[prefix] = uri
+	}
+	return scope
+}
+
+// lookup returns the namespace bound to prefix; the xml prefix is always bound
+func (s namespaceScope) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return XML_NAMESPACE, true
+	}
+	uri, ok := s[prefix]
+	return uri, ok && (uri != "" || prefix == "")
+}
+
+// expand returns the expanded name of an element or attribute name as
+// {uri}local. Unprefixed attributes are in no namespace, and names with an
+// undeclared prefix are returned as written.
+func (s namespaceScope) expand(name string, element bool) string {
+	prefix, local := namePrefix(name), name
+	if prefix != "" {
+		local = name[len(prefix)+1:]
+	} else if !element {
+		return name
+	}
+	uri, ok := s.lookup(prefix)
+	if !ok || uri == "" {
+		return name
+	}
+	return "{" + uri + "}" + local
+}
+
+// unusedPrefixRule only describes the findings of the linter's prefixTracker,
+// which follows declarations through the element stack
+type unusedPrefixRule struct {
+	tokenRule
+	documentRule
+}
+
+func (unusedPrefixRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_UNUSED_PREFIX,
+		Category:    "Namespace",
+		Severity:    SeverityWarning,
+		Description: "A namespace prefix is declared but never used",
+		Fix:         "Remove the unused xmlns declaration",
+	}
+}
+
+// prefixDeclaration is a prefix declared by an open element
+type prefixDeclaration struct {
+	prefix    string
+	line, col int
+	used      bool
+}
+
+// prefixTracker finds declared prefixes that no element or attribute name
+// in the declaring element uses. Attribute values of the form prefix:name
+// count as uses too, as schemas and XSLT refer to types and elements that
+// way.
+type prefixTracker struct {
+	stack [][]prefixDeclaration // Declarations of each open element
+}
+
+// token follows one tag, whose attributes are attrs, and returns the
+// findings of the elements it closes
+func (t *prefixTracker) token(tok token, attrs []tagAttribute) []Finding {
+	switch tok.kind {
+	case TokenStartTag, TokenEmptyTag:
+		var declared []prefixDeclaration
+		for _, a := range attrs {
+			if prefix, ok := declaredPrefix(a.name); ok && prefix != "" && prefix != "xml" {
+				line, col := advance(tok.line, tok.col, tok.raw[:a.nameEnd-len(a.name)])
+				declared = append(declared, prefixDeclaration{prefix: prefix, line: line, col: col})
+			}
+		}
+		t.stack = append(t.stack, declared)
+
+		t.use(namePrefix(tok.name))
+		for _, a := range attrs {
+			if _, ok := declaredPrefix(a.name); ok {
+				continue
+			}
+			t.use(namePrefix(a.name))
+			if a.eq >= 0 {
+				t.use(namePrefix(strings.TrimSpace(a.value(tok.raw))))
+			}
+		}
+		if tok.kind == TokenEmptyTag {
+			return t.pop()
+		}
+	case TokenEndTag:
+		return t.pop()
+	}
+	return nil
+}
+
+// use marks the innermost declaration of prefix as used
+func (t *prefixTracker) use(prefix string) {
+	if prefix == "" {
+		return
+	}
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		for j := range t.stack[i] {
+			if t.stack[i][j].prefix == prefix {
+				t.stack[i][j].used = true
+				return
+			}
+		}
+	}
+}
+
+// pop closes the innermost element and reports its unused declarations
+func (t *prefixTracker) pop() []Finding {
+	if len(t.stack) == 0 {
+		return nil
+	}
+	declared := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	var findings []Finding
+	for _, d := range declared {
+		if !d.used {
+			findings = append(findings, Finding{
+				Message: fmt.Sprintf("Namespace prefix %q is declared but never used", d.prefix),
+				Line:    d.line,
+				Col:     d.col,
+			})
+		}
+	}
+	return findings
+}
+
+// finish reports the unused declarations of elements left open
+func (t *prefixTracker) finish() []Finding {
+	var findings []Finding
+	for len(t.stack) > 0 {
+		findings = append(findings, t.pop()...)
+	}
+	return findings
+}
+
+// repeatedNamespaceRule only describes the findings of hoistNamespaces,
+// which needs the whole document
+type repeatedNamespaceRule struct {
+	tokenRule
+	documentRule
+}
+
+func (repeatedNamespaceRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          WARNING_REPEATED_NAMESPACE,
+		Category:    "Namespace",
+		Severity:    SeverityInfo,
+		Description: "A namespace is declared again where it is already in scope, or on several elements that share an ancestor",
+		Fix:         "Declare each namespace once, on the nearest common ancestor of the elements that use it",
+		Fixed:       "Hoisted repeated namespace declarations",
+		Optional:    true,
+	}
+}
+
+// namespaceElement is an element seen by hoistNamespaces
+type namespaceElement struct {
+	node     *node
+	parent   *namespaceElement
+	depth    int
+	scope    namespaceScope // In scope inside the element
+	declared map[string]tagAttribute
+	uses     map[string]bool // Prefixes its element and attribute names use; "" for an unprefixed element
+}
+
+// namespaceGroup is the declarations of one prefix and namespace
+type namespaceGroup struct {
+	prefix, uri string
+	elements    []*namespaceElement
+}
+
+// hoistNamespaces applies the repeated-namespace rule to the cleaned
+// document content. A declaration the parent already has in scope is
+// redundant and removed. Declarations of the same prefix and namespace on
+// several elements are replaced by one on their nearest common ancestor,
+// provided that changes no name inside it: every element and attribute
+// there using the prefix must already resolve it to that namespace.
+// Findings are reported whenever the rule is enabled; the document is only
+// rewritten with Options.FixWarnings. Removed declarations leave their line
+// breaks behind, so later line numbers still match the input.
+// Every token read is also passed to v, if not nil.
+func hoistNamespaces(content []byte, opts Options, v *validator, res *Result) []byte {
+	root := parseNodes(content, v)
+	info := repeatedNamespaceRule{}.Info()
+
+	var elements []*namespaceElement
+	groups := make(map[string]*namespaceGroup)
+	var keys []string
+	remove := make(map[*node][]tagAttribute)
+	findings := 0
+
+	var walk func(n *node, parent *namespaceElement)
+	walk = func(n *node, parent *namespaceElement) {
+		for _, child := range n.children {
+			if child.tok.kind != TokenStartTag && child.tok.kind != TokenEmptyTag {
+				continue
+			}
+			e := &namespaceElement{node: child, parent: parent, declared: map[string]tagAttribute{}, uses: map[string]bool{}}
+			var scope namespaceScope
+			if parent != nil {
+				e.depth, scope = parent.depth+1, parent.scope
+			}
+			e.scope = scope.declare(child.tok.raw)
+			e.uses[namePrefix(child.tok.name)] = true
+			for _, a := range scanAttributes(child.tok.raw) {
+				prefix, ok := declaredPrefix(a.name)
+				if !ok {
+					if p := namePrefix(a.name); p != "" {
+						e.uses[p] = true
+					}
+					continue
+				}
+				if a.eq < 0 || prefix == "xml" {
+					continue
+				}
+				uri := a.value(child.tok.raw)
+				if inherited, ok := scope[prefix]; ok && inherited == uri {
+					remove[child] = append(remove[child], a)
+					findings++
+					recordWarning(res, info, Finding{
+						Message: fmt.Sprintf("%s declares %s again although it is already in scope", tagLabel(Token{Name: child.tok.name}), a.name),
+						Line:    child.tok.line,
+						Remedy:  "Remove the redundant declaration",
+					})
+					continue
+				}
+				e.declared[prefix] = a
+				key := prefix + "\x00" + uri
+				if _, seen := groups[key]; !seen {
+					groups[key] = &namespaceGroup{prefix: prefix, uri: uri}
+					keys = append(keys, key)
+				}
+				groups[key].elements = append(groups[key].elements, e)
+			}
+			elements = append(elements, e)
+			walk(child, e)
+		}
+	}
+	walk(root, nil)
+
+	add := make(map[*node][]string)
+	for _, key := range keys {
+		g := groups[key]
+		if len(g.elements) < 2 {
+			continue
+		}
+		ancestor := commonAncestor(g.elements)
+		name := "xmlns"
+		if g.prefix != "" {
+			name += ":" + g.prefix
+		}
+		if !canHoist(ancestor, g, elements) {
+			recordWarning(res, info, Finding{
+				Message: fmt.Sprintf("%s=%q is declared on %d elements", name, g.uri, len(g.elements)),
+				Line:    g.elements[1].node.tok.line,
+				Remedy:  fmt.Sprintf("Other names under %s at line %d do not resolve to it; make them agree, then declare it once there", tagLabel(Token{Name: ancestor.node.tok.name}), ancestor.node.tok.line),
+			})
+			continue
+		}
+		for _, e := range g.elements {
+			if e != ancestor {
+				remove[e.node] = append(remove[e.node], e.declared[g.prefix])
+			}
+		}
+		if _, ok := ancestor.declared[g.prefix]; !ok {
+			add[ancestor.node] = append(add[ancestor.node], fmt.Sprintf(`%s="%s"`, name, g.uri))
+		}
+		findings++
+		recordWarning(res, info, Finding{
+			Message: fmt.Sprintf("%s=%q is declared on %d elements", name, g.uri, len(g.elements)),
+			Line:    g.elements[1].node.tok.line,
+			Remedy:  fmt.Sprintf("Declare it once on %s at line %d", tagLabel(Token{Name: ancestor.node.tok.name}), ancestor.node.tok.line),
+		})
+	}
+
+	if !opts.FixWarnings || len(remove) == 0 && len(add) == 0 {
+		return content
+	}
+	moved := make(map[*node]int)
+	for _, e := range elements {
+		if len(remove[e.node]) > 0 || len(add[e.node]) > 0 {
+			e.node.tok.raw, moved[e.node] = rewriteDeclarations(e.node.tok.raw, remove[e.node], add[e.node])
+		}
+	}
+	if findings == 1 {
+		res.Fixes = append(res.Fixes, info.Fixed)
+	} else {
+		res.Fixes = append(res.Fixes, fmt.Sprintf("%s (%d times)", info.Fixed, findings))
+	}
+	var out bytes.Buffer
+	out.Grow(len(content))
+	var write func(n *node)
+	write = func(n *node) {
+		out.WriteString(n.tok.raw)
+		out.WriteString(strings.Repeat("\n", moved[n]))
+		for _, child := range n.children {
+			write(child)
+		}
+		if n.end != nil {
+			out.WriteString(n.end.raw)
+		}
+	}
+	for _, child := range root.children {
+		write(child)
+	}
+	return out.Bytes()
+}
+
+// commonAncestor returns the nearest element that is or contains every element
+func commonAncestor(elements []*namespaceElement) *namespaceElement {
+	ancestor := elements[0]
+	for _, e := range elements[1:] {
+		for e.depth > ancestor.depth {
+			e = e.parent
+		}
+		for ancestor.depth > e.depth {
+			ancestor = ancestor.parent
+		}
+		for e != ancestor {
+			e, ancestor = e.parent, ancestor.parent
+		}
+	}
+	return ancestor
+}
+
+// canHoist reports whether g's declaration can move to ancestor without
+// changing what any name inside it resolves to. Subtrees that bind the
+// prefix to another namespace do not see the hoisted declaration, unless
+// they hold one of g's elements, whose declaration would be removed.
+func canHoist(ancestor *namespaceElement, g *namespaceGroup, elements []*namespaceElement) bool {
+	for _, e := range elements {
+		inside, rebound := false, false
+		for a := e; a != nil && a.depth >= ancestor.depth; a = a.parent {
+			if a == ancestor {
+				inside = true
+				break
+			}
+			if d, ok := a.declared[g.prefix]; ok && d.value(a.node.tok.raw) != g.uri {
+				rebound = true
+			}
+		}
+		if !inside {
+			continue
+		}
+		d, declared := e.declared[g.prefix]
+		switch {
+		case declared && d.value(e.node.tok.raw) != g.uri:
+			// Only the ancestor's own binding is in the way
+			if e == ancestor {
+				return false
+			}
+		case rebound:
+			// One of g's elements, below another binding
+			if declared {
+				return false
+			}
+		case e.uses[g.prefix]:
+			if uri, ok := e.scope[g.prefix]; !ok || uri != g.uri {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rewriteDeclarations removes the given attributes from a start tag, with
+// the whitespace before them on their line, and appends the declarations in
+// add after the last attribute. When nothing but the end of the tag is left
+// on the lines of the removed attributes, the tag is closed on the line
+// before; the line breaks removed are returned, to be written after the tag.
+func rewriteDeclarations(raw string, remove []tagAttribute, add []string) (string, int) {
+	sort.Slice(remove, func(i, j int) bool { return remove[i].nameEnd < remove[j].nameEnd })
+	attrs := scanAttributes(raw)
+	insert := skipName(raw, 1)
+	if len(attrs) > 0 {
+		last := attrs[len(attrs)-1]
+		insert = last.nameEnd
+		if last.eq >= 0 {
+			insert = last.valueEnd
+		}
+	}
+
+	var b strings.Builder
+	pos := 0
+	for _, a := range remove {
+		start := a.nameEnd - len(a.name)
+		for start > pos && (raw[start-1] == ' ' || raw[start-1] == '\t') {
+			start--
+		}
+		b.WriteString(raw[pos:start])
+		pos = a.valueEnd
+	}
+	// The insertion point is the end of an attribute, never inside one
+	// that was removed
+	if insert < pos {
+		insert = pos
+	}
+	b.WriteString(raw[pos:insert])
+	for _, decl := range add {
+		b.WriteString(" " + decl)
+	}
+
+	tag, end := b.String(), raw[insert:]
+	moved := 0
+	if strings.TrimSpace(end) == ">" || strings.TrimSpace(end) == "/>" {
+		kept := strings.TrimRight(tag, " \t\n")
+		moved = strings.Count(tag[len(kept):], "\n") + strings.Count(end, "\n")
+		if moved > 0 {
+			tag, end = kept, strings.TrimSpace(end)
+		}
+	}
+	return tag + end, moved
+}
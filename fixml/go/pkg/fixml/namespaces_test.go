@@ -0,0 +1,88 @@
+package fixml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHoistNamespaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string // After the XML declaration
+		want    string // Output with Options.FixWarnings
+		line    int    // Of the only repeated-namespace finding, counting the declaration
+		message string
+		remedy  string
+	}{
+		{
+			"siblings move to their parent",
+			"<r>\n<a:x xmlns:a=\"u\"/>\n<a:y xmlns:a=\"u\"/>\n</r>\n",
+			"<r xmlns:a=\"u\">\n  <a:x/>\n  <a:y/>\n</r>\n",
+			4, `xmlns:a="u" is declared on 2 elements`, "Declare it once on <r> at line 2",
+		},
+		{
+			"declaration already in scope is removed",
+			"<r xmlns:a=\"u\">\n<a:x xmlns:a=\"u\"/>\n</r>\n",
+			"<r xmlns:a=\"u\">\n  <a:x/>\n</r>\n",
+			3, "<a:x> declares xmlns:a again although it is already in scope", "Remove the redundant declaration",
+		},
+		{
+			"a sibling rebinding the prefix keeps its binding",
+			"<r>\n<x xmlns:p=\"v\"/>\n<z xmlns:p=\"v\"/>\n<k xmlns:p=\"w\"><p:m/></k>\n</r>\n",
+			"<r xmlns:p=\"v\">\n  <x/>\n  <z/>\n  <k xmlns:p=\"w\"><p:m/></k>\n</r>\n",
+			4, `xmlns:p="v" is declared on 2 elements`, "Declare it once on <r> at line 2",
+		},
+		{
+			"blocked by a member under a rebinding",
+			"<r>\n<s xmlns:p=\"w\">\n<p:x xmlns:p=\"v\"/>\n</s>\n<p:z xmlns:p=\"v\"/>\n</r>\n",
+			"<r>\n  <s xmlns:p=\"w\">\n    <p:x xmlns:p=\"v\"/>\n  </s>\n  <p:z xmlns:p=\"v\"/>\n</r>\n",
+			6, `xmlns:p="v" is declared on 2 elements`, "Other names under <r> at line 2 do not resolve to it; make them agree, then declare it once there",
+		},
+		{
+			"blocked by the ancestor's own binding",
+			"<r xmlns:p=\"w\">\n<p:a xmlns:p=\"v\"/>\n<p:b/>\n<p:c xmlns:p=\"v\"/>\n</r>\n",
+			"<r xmlns:p=\"w\">\n  <p:a xmlns:p=\"v\"/>\n  <p:b/>\n  <p:c xmlns:p=\"v\"/>\n</r>\n",
+			5, `xmlns:p="v" is declared on 2 elements`, "Other names under <r> at line 2 do not resolve to it; make them agree, then declare it once there",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, fix := range []bool{false, true} {
+				input := XML_DECLARATION + tt.input
+				var out bytes.Buffer
+				res, err := Process(strings.NewReader(input), &out, Options{FixWarnings: fix, Warnings: map[string]bool{WARNING_REPEATED_NAMESPACE: true}})
+				if err != nil {
+					t.Fatalf("Process failed: %v", err)
+				}
+
+				var found []Warning
+				for _, w := range res.Warnings {
+					if w.ID == WARNING_REPEATED_NAMESPACE {
+						found = append(found, w)
+					}
+				}
+				if len(found) != 1 || found[0].Line != tt.line || found[0].Message != tt.message || found[0].Fix != tt.remedy {
+					t.Errorf("FixWarnings=%v: findings = %+v, want %q on line %d with remedy %q", fix, found, tt.message, tt.line, tt.remedy)
+				}
+
+				want := XML_DECLARATION + tt.want
+				if !fix {
+					// Only reported: the output is that of the rule disabled
+					var plain bytes.Buffer
+					if _, err := Process(strings.NewReader(input), &plain, Options{}); err != nil {
+						t.Fatalf("Process failed: %v", err)
+					}
+					want = plain.String()
+				}
+				if out.String() != want {
+					t.Errorf("FixWarnings=%v: output =\n%s\nwant\n%s", fix, out.String(), want)
+				}
+				hoisted := strings.HasPrefix(tt.remedy, "Declare") || strings.HasPrefix(tt.remedy, "Remove")
+				if got := len(res.Fixes) > 0 && res.Fixes[0] == "Hoisted repeated namespace declarations"; got != (fix && hoisted) {
+					t.Errorf("FixWarnings=%v: Fixes = %q", fix, res.Fixes)
+				}
+			}
+		})
+	}
+}
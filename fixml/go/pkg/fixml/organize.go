@@ -0,0 +1,180 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DEFAULT_ORGANIZE_ORDER is the group order applied by Organize when
+// Options.OrganizeOrder is empty: MSBuild property groups before item groups,
+// and items grouped by kind with package and project references first.
+var DEFAULT_ORGANIZE_ORDER = []string{
+	"PropertyGroup", "ItemGroup",
+	"PackageReference", "ProjectReference", "Reference",
+	"Compile", "Content", "None", "EmbeddedResource",
+}
+
+// node is an element subtree or a single non-element token of the document
+type node struct {
+	tok      token // Start or empty-element tag for elements
+	children []*node
+	end      *token // End tag; nil for empty elements and leaves
+}
+
+// organize reorders sibling elements of the cleaned document content.
+// Siblings named in order are gathered by name and sorted by their position
+// in order; they move only among the places such elements already occupy,
+// so every other element (an Import or Target, say) keeps its position.
+// Comments and processing instructions move with the element that follows
+// them. Elements with text or CDATA content are left as they are.
+// The second result holds, for each line of the reordered content, the line
+// of content it comes from, so findings can be reported at input lines.
+// Every token read is also passed to v, if not nil.
+func organize(content []byte, order []string, v *validator) ([]byte, []int) {
+	if len(order) == 0 {
+		order = DEFAULT_ORGANIZE_ORDER
+	}
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		if _, ok := rank[name]; !ok {
+			rank[name] = i
+		}
+	}
+
+	root := parseNodes(content, v)
+	var out bytes.Buffer
+	out.Grow(len(content))
+	lines := []int{1}
+	for _, child := range root.children {
+		writeOrganized(&out, &lines, child, rank)
+	}
+	return out.Bytes(), lines
+}
+
+// writeToken writes tok and records where the lines it starts come from
+func writeToken(out *bytes.Buffer, lines *[]int, tok token) {
+	out.WriteString(tok.raw)
+	line := tok.line
+	for i := 0; i < len(tok.raw); i++ {
+		if tok.raw[i] == '\n' {
+			line++
+			*lines = append(*lines, line)
+		}
+	}
+}
+
+// inputLine returns the line of the content given to organize that line n
+// of its result comes from; n itself without a line map or for n == 0
+func inputLine(lines []int, n int) int {
+	if n < 1 || n > len(lines) {
+		return n
+	}
+	return lines[n-1]
+}
+
+// parseNodes builds the node tree of the cleaned document content under an
+// empty root node. Every token read is also passed to v, if not nil.
+func parseNodes(content []byte, v *validator) *node {
+	tokens := newTokenizer(bufio.NewReaderSize(bytes.NewReader(content), IO_CHUNK_SIZE))
+	root := &node{}
+	stack := []*node{root}
+	for {
+		tok, err := tokens.next()
+		if err == io.EOF {
+			break
+		}
+		if v != nil {
+			v.token(tok)
+		}
+		parent := stack[len(stack)-1]
+		switch {
+		case tok.kind == TokenEndTag && len(stack) > 1:
+			parent.end = &tok
+			stack = stack[:len(stack)-1]
+		case tok.kind == TokenStartTag:
+			child := &node{tok: tok}
+			parent.children = append(parent.children, child)
+			stack = append(stack, child)
+		default:
+			parent.children = append(parent.children, &node{tok: tok})
+		}
+	}
+	return root
+}
+
+// unit is an element together with the whitespace, comments and processing
+// instructions before it; units are what organize moves around
+type unit struct {
+	nodes []*node
+	name  string
+}
+
+func writeOrganized(out *bytes.Buffer, lines *[]int, n *node, rank map[string]int) {
+	writeToken(out, lines, n.tok)
+	if n.tok.kind != TokenStartTag {
+		return
+	}
+
+	children := n.children
+	if units, trailer, ok := splitUnits(children); ok {
+		reorderUnits(units, rank)
+		children = children[:0:0]
+		for _, u := range units {
+			children = append(children, u.nodes...)
+		}
+		children = append(children, trailer...)
+	}
+
+	for _, child := range children {
+		writeOrganized(out, lines, child, rank)
+	}
+	if n.end != nil {
+		writeToken(out, lines, *n.end)
+	}
+}
+
+// splitUnits divides element-only content into units and the nodes after the
+// last element. It reports false for content with text, CDATA or markup
+// that cannot be moved safely.
+func splitUnits(children []*node) ([]unit, []*node, bool) {
+	var units []unit
+	start := 0
+	for i, child := range children {
+		switch child.tok.kind {
+		case TokenText:
+			if strings.TrimSpace(child.tok.raw) != "" {
+				return nil, nil, false
+			}
+		case TokenComment, TokenProcInst:
+		case TokenStartTag, TokenEmptyTag:
+			units = append(units, unit{nodes: children[start : i+1], name: child.tok.name})
+			start = i + 1
+		default:
+			return nil, nil, false
+		}
+	}
+	return units, children[start:], true
+}
+
+// reorderUnits sorts the units named in rank by their rank, keeping the
+// input order within each name, and puts them back into the slots such
+// units occupied
+func reorderUnits(units []unit, rank map[string]int) {
+	var slots []int
+	var ranked []unit
+	for i, u := range units {
+		if _, ok := rank[u.name]; ok {
+			slots = append(slots, i)
+			ranked = append(ranked, u)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rank[ranked[i].name] < rank[ranked[j].name]
+	})
+	for i, slot := range slots {
+		units[slot] = ranked[i]
+	}
+}
@@ -0,0 +1,65 @@
+package fixml
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Organize reorders lines before formatting; what is reported still refers
+// to the input
+func TestOrganizeReportsInputLines(t *testing.T) {
+	input := XML_DECLARATION + `<Project>
+<ItemGroup>
+<Compile Include="a.cs" />
+<Compile Include="a.cs" />
+</ItemGroup>
+<PropertyGroup>
+<Nullable>enable</Nullable>
+<Nullable>enable</Nullable>
+<Empty></Empty>
+</PropertyGroup>
+</Project>
+`
+	want := XML_DECLARATION + `<Project>
+  <PropertyGroup>
+    <Nullable>enable</Nullable>
+    <Empty></Empty>
+  </PropertyGroup>
+  <ItemGroup>
+    <Compile Include="a.cs" />
+  </ItemGroup>
+</Project>
+`
+	var out bytes.Buffer
+	res, err := Process(strings.NewReader(input), &out, Options{
+		Organize:         true,
+		ReportDuplicates: true,
+		Verify:           true,
+		Warnings:         map[string]bool{WARNING_EMPTY_ELEMENT: true},
+	})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	duplicates := []Duplicate{
+		{Line: 5, Text: `<Compile Include="a.cs" />`, DuplicateOf: 4},
+		{Line: 9, Text: "<Nullable>enable</Nullable>", DuplicateOf: 8},
+	}
+	if !reflect.DeepEqual(res.Duplicates, duplicates) {
+		t.Errorf("Duplicates = %+v, want %+v", res.Duplicates, duplicates)
+	}
+	var lines []int
+	for _, w := range res.Warnings {
+		if w.ID == WARNING_EMPTY_ELEMENT {
+			lines = append(lines, w.Line)
+		}
+	}
+	if !reflect.DeepEqual(lines, []int{10}) {
+		t.Errorf("%s reported at lines %v, want [10]", WARNING_EMPTY_ELEMENT, lines)
+	}
+}
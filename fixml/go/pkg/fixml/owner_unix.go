@@ -0,0 +1,21 @@
+//go:build unix
+
+package fixml
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the owner and group described by info. Only privileged
+// processes may change the owner, so otherwise just the group is copied,
+// and failures are ignored.
+func copyOwner(f *os.File, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if f.Chown(int(stat.Uid), int(stat.Gid)) != nil {
+		f.Chown(-1, int(stat.Gid))
+	}
+}
@@ -0,0 +1,57 @@
+package fixml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPreserveSpace(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  Options
+		want  string
+	}{
+		{
+			"xml:space=\"preserve\" is written as read, without dedup",
+			"<doc>\n<p xml:space=\"preserve\">\n   a   b\n\n     <i>x</i>\n<i>x</i>\n</p>\n<q>\n<i>x</i>\n<i>x</i>\n</q>\n</doc>\n",
+			Options{},
+			"<doc>\n  <p xml:space=\"preserve\">\n   a   b\n\n     <i>x</i>\n<i>x</i>\n</p>\n  <q>\n    <i>x</i>\n  </q>\n</doc>\n",
+		},
+		{
+			"listed element names",
+			"<doc>\n<pre>\n   code\n      <b>y</b>\n</pre>\n</doc>\n",
+			Options{PreserveSpace: []string{"pre"}},
+			"<doc>\n  <pre>\n   code\n      <b>y</b>\n</pre>\n</doc>\n",
+		},
+		{
+			"elements not listed are reindented",
+			"<doc>\n<pre>\n   code\n      <b>y</b>\n</pre>\n</doc>\n",
+			Options{PreserveSpace: []string{"code"}},
+			"<doc>\n  <pre>\n    code\n    <b>y</b>\n  </pre>\n</doc>\n",
+		},
+		{
+			"xml:space=\"default\" ends a preserved region",
+			"<doc xml:space=\"preserve\">\n<a xml:space=\"default\">\n<b/>\n</a>\n  <c/>\n</doc>\n",
+			Options{},
+			"<doc xml:space=\"preserve\">\n<a xml:space=\"default\">\n    <b/>\n  </a>\n  <c/>\n</doc>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.opts.Verify = true // Also fails when preserved text changes
+			res, err := Process(strings.NewReader(XML_DECLARATION+tt.input), &out, tt.opts)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if want := XML_DECLARATION + tt.want; out.String() != want {
+				t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+			}
+			if len(res.Errors) > 0 {
+				t.Errorf("Errors = %+v", res.Errors)
+			}
+		})
+	}
+}
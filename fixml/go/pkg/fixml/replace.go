@@ -0,0 +1,143 @@
+package fixml
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// REPLACE_TEMP_SUFFIX ends the names of the temporary files written next to
+// a file being replaced; they are hidden and unique per run
+const REPLACE_TEMP_SUFFIX = ".fixml-tmp"
+
+// replaceFile writes a new version of path through write and moves it into
+// place with a single rename, so readers see either the old or the new
+// content. Symlinks are followed and the file they point to is replaced.
+// With backup set, the original is kept at that path just before the rename.
+func replaceFile(path, backup string, write func(io.Writer) error) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("could not read file '%s': %v", path, err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("could not read file '%s': %v", path, err)
+	}
+
+	return writeAtomic(target, info, write, func() error {
+		if backup == "" {
+			return nil
+		}
+		if err := backupFile(target, backup, info); err != nil {
+			return fmt.Errorf("could not back up '%s': %v", path, err)
+		}
+		return nil
+	})
+}
+
+// RestoreFile puts the content of a backup written by a replace run back at
+// path, with the same atomic replacement. The backup itself is left alone.
+func RestoreFile(backup, path string) error {
+	in, err := os.Open(backup)
+	if err != nil {
+		return fmt.Errorf("could not read backup '%s': %v", backup, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read backup '%s': %v", backup, err)
+	}
+
+	target := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		target = resolved
+	}
+	return writeAtomic(target, info, func(w io.Writer) error {
+		if _, err := io.Copy(w, in); err != nil {
+			return fmt.Errorf("could not read backup '%s': %v", backup, err)
+		}
+		return nil
+	}, nil)
+}
+
+// writeAtomic creates a uniquely named temporary file next to target with
+// the mode and, where the platform allows, the owner described by info,
+// fills it through write, syncs it and renames it over target. beforeRename,
+// if not nil, runs once the new content is safely on disk. The temporary
+// file is removed on any failure.
+func writeAtomic(target string, info os.FileInfo, write func(io.Writer) error, beforeRename func() error) error {
+	dir := filepath.Dir(target)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*"+REPLACE_TEMP_SUFFIX)
+	if err != nil {
+		return fmt.Errorf("could not write output file: %v", err)
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// The owner goes first: changing it may clear setuid and setgid bits
+	copyOwner(tmp, info)
+	if err := tmp.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+		return fmt.Errorf("could not write output file: %v", err)
+	}
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("could not write output file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write output file: %v", err)
+	}
+
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("could not replace original file: %v", err)
+	}
+	renamed = true
+	syncDir(dir)
+	return nil
+}
+
+// backupFile keeps the current content of file at backup. A hard link costs
+// nothing and survives the rename; across file systems the file is copied.
+func backupFile(file, backup string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(backup), DIR_PERMISSIONS); err != nil {
+		return err
+	}
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.Link(file, backup) == nil {
+		return nil
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeAtomic(backup, info, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	}, nil)
+}
+
+// syncDir makes a rename in dir durable. Not every platform can sync a
+// directory, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
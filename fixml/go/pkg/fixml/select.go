@@ -0,0 +1,220 @@
+package fixml
+
+import (
+	"fmt"
+	"strings"
+)
+
+const MAX_SELECTOR_STEPS = 64 // Steps of one selector; match states are kept in a bit mask
+
+// Selector is a compiled element path in a practical subset of XPath:
+// child steps (/a/b), descendant steps (//b), name tests (a, x:a or *) and
+// attribute predicates ([@Include], [@Include='a.cs'], several in a row).
+// A path that does not start with / matches at any depth, as if it started
+// with //. Names are compared as written, prefix included.
+type Selector struct {
+	expr  string
+	steps []selectorStep
+}
+
+// selectorStep is one location step of a Selector
+type selectorStep struct {
+	name       string // "*" for any element
+	descendant bool   // Preceded by //: the element may be any descendant of the previous one
+	predicates []attributePredicate
+}
+
+// attributePredicate is an [@name] or [@name='value'] test
+type attributePredicate struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// ParseSelector compiles an element path such as //ItemGroup/Compile[@Include].
+func ParseSelector(expr string) (Selector, error) {
+	sel := Selector{expr: expr}
+	s := strings.TrimSpace(expr)
+	if s == "" {
+		return sel, fmt.Errorf("empty path")
+	}
+	descendant := !strings.HasPrefix(s, "/")
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "//"):
+			descendant, s = true, s[2:]
+		case strings.HasPrefix(s, "/"):
+			s = s[1:]
+		}
+
+		// Name test
+		end := 0
+		for end < len(s) && s[end] != '/' && s[end] != '[' {
+			end++
+		}
+		name := strings.TrimSpace(s[:end])
+		if name != "*" && !isXMLName(name) {
+			if name == "" {
+				return sel, fmt.Errorf("missing element name at %q", s)
+			}
+			return sel, fmt.Errorf("invalid element name %q", name)
+		}
+		step := selectorStep{name: name, descendant: descendant}
+		s = s[end:]
+
+		for strings.HasPrefix(s, "[") {
+			close := strings.IndexByte(s, ']')
+			if close < 0 {
+				return sel, fmt.Errorf("unclosed predicate %q", s)
+			}
+			p, err := parsePredicate(strings.TrimSpace(s[1:close]))
+			if err != nil {
+				return sel, err
+			}
+			step.predicates = append(step.predicates, p)
+			s = s[close+1:]
+		}
+		if s != "" && s[0] != '/' {
+			return sel, fmt.Errorf("unexpected %q", s)
+		}
+		if s == "/" || s == "//" {
+			return sel, fmt.Errorf("path ends with %q", s)
+		}
+
+		sel.steps = append(sel.steps, step)
+		descendant = false
+	}
+	if len(sel.steps) > MAX_SELECTOR_STEPS {
+		return sel, fmt.Errorf("more than %d steps", MAX_SELECTOR_STEPS)
+	}
+	return sel, nil
+}
+
+// parsePredicate compiles the inside of [@name] or [@name='value']
+func parsePredicate(s string) (attributePredicate, error) {
+	var p attributePredicate
+	if !strings.HasPrefix(s, "@") {
+		return p, fmt.Errorf("unsupported predicate [%s]; only attribute tests like [@Include] are supported", s)
+	}
+	name, value, hasValue := strings.Cut(s[1:], "=")
+	p.name, p.hasValue = strings.TrimSpace(name), hasValue
+	if !isXMLName(p.name) {
+		return p, fmt.Errorf("invalid attribute name in [%s]", s)
+	}
+	if hasValue {
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || value[0] != value[len(value)-1] || value[0] != '\'' && value[0] != '"' {
+			return p, fmt.Errorf("attribute value in [%s] must be quoted", s)
+		}
+		p.value = value[1 : len(value)-1]
+	}
+	return p, nil
+}
+
+func (s Selector) String() string {
+	return s.expr
+}
+
+// matches reports whether step i of s accepts the element started by tok
+func (s Selector) matches(i int, tok token, attrs map[string]string) bool {
+	step := s.steps[i]
+	if step.name != "*" && step.name != tok.name {
+		return false
+	}
+	for _, p := range step.predicates {
+		value, ok := attrs[p.name]
+		if !ok || p.hasValue && value != p.value {
+			return false
+		}
+	}
+	return true
+}
+
+// selection decides which elements Options.Only and Options.Skip select.
+// Every open element carries a selectionState, so paths are matched in the
+// same single pass that formats the document.
+type selection struct {
+	selectors []Selector // The Only selectors, then the Skip selectors
+	only      int        // Number of Only selectors
+}
+
+// selectionState is where the selectors stand inside an element. For each
+// selector, bit i of next is set when step i may match a child, and bit i
+// of below when it may match any deeper descendant.
+type selectionState struct {
+	next, below []uint64
+	inOnly      bool // The element or an ancestor matches an Only selector
+	inSkip      bool // The element or an ancestor matches a Skip selector
+}
+
+// newSelection compiles Options.Only and Options.Skip; it returns nil when
+// there are none, so every element is selected
+func newSelection(only, skip []string) (*selection, error) {
+	if len(only) == 0 && len(skip) == 0 {
+		return nil, nil
+	}
+	sel := &selection{only: len(only)}
+	for _, expr := range append(only[:len(only):len(only)], skip...) {
+		s, err := ParseSelector(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", expr, err)
+		}
+		sel.selectors = append(sel.selectors, s)
+	}
+	return sel, nil
+}
+
+// root returns the state at the top of the document, outside any element
+func (sel *selection) root() selectionState {
+	n := len(sel.selectors)
+	state := selectionState{next: make([]uint64, n), below: make([]uint64, n), inOnly: sel.only == 0}
+	for i := range state.next {
+		state.next[i] = 1
+	}
+	return state
+}
+
+// enter returns the state inside the element started by tok, a child of
+// the element with state parent
+func (sel *selection) enter(parent selectionState, tok token) selectionState {
+	state := selectionState{
+		next:   make([]uint64, len(parent.next)),
+		below:  make([]uint64, len(parent.below)),
+		inOnly: parent.inOnly,
+		inSkip: parent.inSkip,
+	}
+	var attrs map[string]string
+	for k, s := range sel.selectors {
+		candidates := parent.next[k] | parent.below[k]
+		below := parent.below[k]
+		for i := range s.steps {
+			bit := uint64(1) << i
+			if s.steps[i].descendant && parent.next[k]&bit != 0 {
+				below |= bit
+			}
+			if candidates&bit == 0 {
+				continue
+			}
+			if attrs == nil && len(s.steps[i].predicates) > 0 {
+				attrs = attributeValues(tok.raw)
+			}
+			if !s.matches(i, tok, attrs) {
+				continue
+			}
+			if i+1 < len(s.steps) {
+				state.next[k] |= bit << 1
+			} else if k < sel.only {
+				state.inOnly = true
+			} else {
+				state.inSkip = true
+			}
+		}
+		state.below[k] = below
+	}
+	return state
+}
+
+// selected reports whether the element is reindented and deduplicated
+func (s selectionState) selected() bool {
+	return s.inOnly && !s.inSkip
+}
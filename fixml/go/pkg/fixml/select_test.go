@@ -0,0 +1,78 @@
+package fixml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []selectorStep
+	}{
+		{"ItemGroup", []selectorStep{{name: "ItemGroup", descendant: true}}},
+		{"/Project", []selectorStep{{name: "Project"}}},
+		{"//ItemGroup/Compile", []selectorStep{{name: "ItemGroup", descendant: true}, {name: "Compile"}}},
+		{"/Project//Compile", []selectorStep{{name: "Project"}, {name: "Compile", descendant: true}}},
+		{"  /a/*/x:b  ", []selectorStep{{name: "a"}, {name: "*"}, {name: "x:b"}}},
+		{"//Compile[@Include]", []selectorStep{{name: "Compile", descendant: true, predicates: []attributePredicate{{name: "Include"}}}}},
+		{`//Compile[@Include='a.cs'][ @Link = "b/c.cs" ]`, []selectorStep{{name: "Compile", descendant: true, predicates: []attributePredicate{
+			{name: "Include", value: "a.cs", hasValue: true},
+			{name: "Link", value: "b/c.cs", hasValue: true},
+		}}}},
+		{"//*[@x:id='']", []selectorStep{{name: "*", descendant: true, predicates: []attributePredicate{{name: "x:id", hasValue: true}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sel, err := ParseSelector(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseSelector(%q) failed: %v", tt.expr, err)
+			}
+			if !reflect.DeepEqual(sel.steps, tt.want) {
+				t.Errorf("ParseSelector(%q) = %+v, want %+v", tt.expr, sel.steps, tt.want)
+			}
+			if sel.String() != tt.expr {
+				t.Errorf("String() = %q, want %q", sel.String(), tt.expr)
+			}
+		})
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "empty path"},
+		{"   ", "empty path"},
+		{"/", "missing element name"},
+		{"//a///b", "missing element name"},
+		{"/a/", `path ends with "/"`},
+		{"//a//", `path ends with "//"`},
+		{"/1a", `invalid element name "1a"`},
+		{"/a b", `invalid element name "a b"`},
+		{"/a[@x", `unclosed predicate "[@x"`},
+		{"/a[1]", "unsupported predicate [1]"},
+		{"/a[b='c']", "unsupported predicate [b='c']"},
+		{"/a[@]", "invalid attribute name in [@]"},
+		{"/a[@x=y]", "attribute value in [@x=y] must be quoted"},
+		{`/a[@x='y"]`, "must be quoted"},
+		{"/a[@x]b", `unexpected "b"`},
+		{strings.Repeat("/a", MAX_SELECTOR_STEPS+1), "more than 64 steps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sel, err := ParseSelector(tt.expr)
+			if err == nil {
+				t.Fatalf("ParseSelector(%q) = %+v, want error %q", tt.expr, sel.steps, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseSelector(%q) error = %q, want %q", tt.expr, err, tt.want)
+			}
+		})
+	}
+	if _, err := ParseSelector(strings.Repeat("/a", MAX_SELECTOR_STEPS)); err != nil {
+		t.Errorf("ParseSelector with %d steps failed: %v", MAX_SELECTOR_STEPS, err)
+	}
+}
@@ -0,0 +1,622 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// hasXMLDeclaration peeks at the start of the cleaned stream so the
+// declaration is known before the first output line is written
+func hasXMLDeclaration(reader *bufio.Reader) bool {
+	head, _ := reader.Peek(IO_CHUNK_SIZE)
+	for len(head) > 0 && head[0] <= WHITESPACE_THRESHOLD {
+		head = head[1:]
+	}
+	return bytes.HasPrefix(head, []byte("<?xml"))
+}
+
+// processAsText formats the cleaned stream and records statistics in res.
+// The tokenizer decides nesting depth and which lines are complete elements,
+// while the original line breaks are kept, so the document structure is
+// preserved. Lines are written as soon as they are complete; only elements
+// that may still turn out to be duplicates (at most MAX_SUBTREE_BYTES) and
+// the deduplication hashes are held in memory.
+// Tokens are also passed to v, if not nil, to check well-formedness.
+// When Organize has reordered the lines, lines maps them back to the input
+// (see organize) for the findings and duplicates reported; nil keeps them.
+func processAsText(opts Options, reader *bufio.Reader, output *bufio.Writer, size int, v *validator, lines []int, res *Result) error {
+	warnings := len(res.Warnings)
+	l := newLinter(opts, Document{HasXMLDeclaration: res.HasXMLDeclaration, Encoding: outputEncoding(opts, res)}, res)
+	output.WriteString(l.start())
+
+	// Pre-allocate map with estimated size to avoid rehashing
+	estimatedElements := size / ESTIMATED_LINE_LENGTH // Estimate based on standard line length
+	if estimatedElements < MIN_HASH_CAPACITY {
+		estimatedElements = MIN_HASH_CAPACITY
+	}
+	if estimatedElements > MAX_HASH_CAPACITY {
+		estimatedElements = MAX_HASH_CAPACITY
+	}
+
+	f := newFormatter(opts, output, make(map[uint64]int, estimatedElements))
+	// Rules look one token ahead; an end tag merged into its start tag by a
+	// fix is only validated
+	tokens := newTokenizer(reader)
+	tok, err := tokens.next()
+	for err == nil {
+		next, nextErr := tokens.next()
+		var peek *token
+		if nextErr == nil {
+			peek = &next
+		}
+		if v != nil {
+			v.token(tok)
+		}
+		fixed, merged := l.token(tok, peek)
+		f.token(fixed)
+		if merged {
+			if v != nil {
+				v.token(next)
+			}
+			next, nextErr = tokens.next()
+		}
+		tok, err = next, nextErr
+	}
+	if err != io.EOF {
+		return fmt.Errorf("error reading content: %v", err)
+	}
+	l.finish()
+	for i := warnings; i < len(res.Warnings); i++ {
+		res.Warnings[i].Line = inputLine(lines, res.Warnings[i].Line)
+	}
+	// After the final line break only whitespace may be left, which lines
+	// written as read would otherwise keep
+	if fastTrimSpace(string(f.line)) != "" {
+		f.endLine(false)
+	}
+	f.flush()
+
+	if f.err != nil {
+		return fmt.Errorf("could not write output: %v", f.err)
+	}
+	for i := range f.duplicates {
+		d := &f.duplicates[i]
+		d.Line, d.DuplicateOf = inputLine(lines, d.Line), inputLine(lines, d.DuplicateOf)
+	}
+	// Earlier passes may have removed elements already, and Organize leaves
+	// the formatter's out of input order
+	res.DuplicatesRemoved += f.removed
+	if len(res.Duplicates) > 0 || lines != nil {
+		res.Duplicates = append(res.Duplicates, f.duplicates...)
+		sort.SliceStable(res.Duplicates, func(i, j int) bool {
+			return res.Duplicates[i].Line < res.Duplicates[j].Line
+		})
+	} else {
+		res.Duplicates = f.duplicates
+	}
+	return nil
+}
+
+// element is an open element on the formatter's stack
+type element struct {
+	name     string
+	seen     map[uint64]int // Input line of each child kept so far, by hash; nil until needed
+	start    int            // Offset of the element's first line in pending, or -1
+	line     int            // Input line of the start tag
+	dups     int            // Duplicates removed before the element started
+	preserve bool           // Whitespace inside the element is significant
+	ns       namespaceScope // Namespaces in scope inside the element
+	sel      selectionState // Options.Only and Options.Skip inside the element
+}
+
+// formatter reassembles tokens into the original lines and writes each line
+// reindented to its nesting depth. Every line break is kept; a line that
+// breaks inside a token is a continuation of that token.
+//
+// Deduplication is scoped by the element stack: a line or a multi-line
+// element is removed when an identical sibling was kept earlier under the
+// same parent (or anywhere, with DedupGlobal). Lines of an element that
+// starts its own line are held in pending until it closes, so the whole
+// element can be dropped if it repeats an earlier sibling. The stack also
+// carries the namespaces in scope, so names are compared expanded.
+//
+// Inside elements with xml:space="preserve", or named in
+// Options.PreserveSpace, lines are written exactly as read, and so are the
+// lines of elements Options.Only and Options.Skip do not select.
+type formatter struct {
+	opts        Options
+	output      *bufio.Writer
+	rootSeen    map[uint64]int  // Top-level siblings, and the whole document with DedupGlobal
+	exempt      map[string]bool // Options.DedupExempt
+	preserve    map[string]bool // Options.PreserveSpace
+	selection   *selection      // Options.Only and Options.Skip; nil selects everything
+	rootSel     selectionState  // Selection outside the root element
+	indentCache []string
+
+	depth   int       // Element depth after the tokens seen so far
+	stack   []element // Open elements, pushed as soon as their start tag begins
+	pending []byte    // Formatted lines held back for open candidate elements
+	open    int       // Open elements with start >= 0
+
+	line   []byte // Current line as read, without its newline
+	lineNo int    // Input line number of the current line
+
+	// State of the current line
+	startDepth   int       // Depth at the start of the line
+	minDepth     int       // Lowest depth reached on the line
+	continued    bool      // The line starts inside a token that began on an earlier line
+	contKind     TokenKind // Kind of that token
+	contIndent   int       // Indentation of continuation lines of that token, in levels
+	contAlign    int       // Spaces added after that indentation
+	hasContent   bool      // Any non-whitespace token so far
+	hasElement   bool      // The line contains a start, end or empty-element tag
+	hasText      bool      // Non-whitespace text at the depth the line starts at
+	hasExempt    bool      // An element exempt from deduplication starts at that depth
+	hasKept      bool      // A comment, CDATA section or PI that policy never removes
+	hasNode      bool      // A comment, CDATA section or PI that policy deduplicates
+	preserved    bool      // The line starts inside an element that preserves whitespace
+	hasPreserved bool      // Part of the line is inside such an element
+	untouched    bool      // The line starts inside, or with, an element that is not selected
+	hasUntouched bool      // Part of the line is inside such an element
+	closed       int       // Start of a candidate element closed last on this line, or -1
+	closedLine   int       // Input line of that element's start tag
+	closedDups   int       // Duplicates removed before that element started
+
+	removed    int         // Duplicates removed so far
+	duplicates []Duplicate // The removed duplicates, with Options.ReportDuplicates
+	err        error       // First write error
+}
+
+func newFormatter(opts Options, output *bufio.Writer, seen map[uint64]int) *formatter {
+	// Pre-cache common indentation strings in the configured style
+	indentCache := make([]string, MAX_INDENT_LEVELS+1)
+	unit := indentUnit(opts)
+	for i := 0; i < len(indentCache); i++ {
+		indentCache[i] = strings.Repeat(unit, i)
+	}
+	exempt := make(map[string]bool, len(opts.DedupExempt))
+	for _, name := range opts.DedupExempt {
+		exempt[name] = true
+	}
+	preserve := make(map[string]bool, len(opts.PreserveSpace))
+	for _, name := range opts.PreserveSpace {
+		preserve[name] = true
+	}
+	f := &formatter{opts: opts, output: output, rootSeen: seen, exempt: exempt, preserve: preserve, indentCache: indentCache, lineNo: 1, closed: -1}
+	// Process has rejected invalid selectors already
+	if f.selection, _ = newSelection(opts.Only, opts.Skip); f.selection != nil {
+		f.rootSel = f.selection.root()
+	}
+	f.untouched = !f.selecting()
+	f.hasUntouched = f.untouched
+	return f
+}
+
+// token adds one token to the current line, ending the line at each newline
+func (f *formatter) token(tok token) {
+	blank := tok.kind == TokenText && fastTrimSpace(tok.raw) == ""
+	if !blank {
+		// Content after an end tag shares its line, so that element can no
+		// longer be removed on its own
+		f.closed = -1
+	}
+
+	// Content the selectors leave out is written as read, and neither it
+	// nor the elements around it are removed. A line is written as read
+	// unless its first token is selected.
+	var sel selectionState
+	if f.selection != nil && !blank {
+		selected := f.selecting() // An end tag belongs to the element it closes
+		if tok.kind == TokenStartTag || tok.kind == TokenEmptyTag {
+			parent := f.rootSel
+			if len(f.stack) > 0 {
+				parent = f.stack[len(f.stack)-1].sel
+			}
+			sel = f.selection.enter(parent, tok)
+			selected = sel.selected()
+			if !selected {
+				f.keepOpen()
+			}
+		}
+		if !f.continued && !f.hasContent {
+			f.untouched, f.hasUntouched = !selected, !selected
+		} else if !selected {
+			f.hasUntouched = true
+		}
+	}
+
+	switch tok.kind {
+	case TokenEndTag:
+		if f.depth > 0 {
+			f.depth--
+			if f.depth < f.minDepth {
+				f.minDepth = f.depth
+			}
+			f.pop()
+		}
+	case TokenStartTag:
+		// An element whose start tag begins a line is a candidate for
+		// multi-line deduplication. The document element is not: it has
+		// nothing to repeat, and holding it back would hold the whole
+		// document once its children are removed. Whitespace the hash
+		// ignores may matter in an element that preserves it, so neither it
+		// nor the elements around it can be removed.
+		preserve := f.preserves(tok)
+		if preserve {
+			f.hasPreserved = true
+			f.keepOpen()
+		}
+		candidate := f.depth > 0 && !f.continued && !f.hasContent && !f.exempt[tok.name] && !preserve && (f.selection == nil || sel.selected())
+		f.push(tok, candidate, preserve, sel)
+	}
+	switch tok.kind {
+	case TokenStartTag, TokenEmptyTag:
+		if f.depth == f.startDepth && f.exempt[tok.name] {
+			f.hasExempt = true
+		}
+	}
+	if policy, ok := f.opts.nodePolicy(tok.kind); ok {
+		if policy == NodeKeep {
+			f.hasKept = true
+			f.keepOpen()
+		} else {
+			f.hasNode = true
+		}
+	}
+
+	// Attributes continued on later lines are indented below their tag;
+	// comments, PIs and declarations keep the level they start at
+	contIndent, contAlign := f.depth, 0
+	switch tok.kind {
+	case TokenStartTag, TokenEmptyTag, TokenEndTag:
+		contIndent, contAlign = f.attributeIndent(tok)
+	}
+
+	raw := tok.raw
+	for {
+		segment, rest, more := strings.Cut(raw, "\n")
+		f.line = append(f.line, segment...)
+		switch tok.kind {
+		case TokenStartTag, TokenEmptyTag, TokenEndTag:
+			f.hasElement = true
+		case TokenText:
+			if f.depth <= f.startDepth && fastTrimSpace(segment) != "" {
+				f.hasText = true
+			}
+		}
+		if !blank {
+			f.hasContent = true
+		}
+		if !more {
+			break
+		}
+
+		f.endLine(tok.kind != TokenText)
+		if tok.kind != TokenText {
+			f.continued, f.contKind, f.contIndent, f.contAlign = true, tok.kind, contIndent, contAlign
+		}
+		raw = rest
+	}
+
+	if tok.kind == TokenStartTag {
+		f.depth++
+	}
+}
+
+// attributeIndent returns the indentation of lines continuing tok, a tag
+// that starts on the current line
+func (f *formatter) attributeIndent(tok token) (int, int) {
+	levels := f.opts.AttributeIndent
+	if levels == 0 {
+		levels = 1
+	}
+	if levels != ATTRIBUTE_INDENT_ALIGN {
+		return f.depth + levels, 0
+	}
+
+	// Align with the first attribute when it is on the tag's first line;
+	// otherwise there is nothing to align with
+	first, _, _ := strings.Cut(tok.raw, "\n")
+	attr := skipSpace(first, skipName(first, 1))
+	if tok.kind != TokenStartTag && tok.kind != TokenEmptyTag || attr == len(first) {
+		return f.depth + 1, 0
+	}
+	indent, align := f.minDepth, 0
+	if f.continued {
+		indent, align = f.contIndent, f.contAlign
+	}
+	return indent, align + utf8.RuneCountInString(trimLeftSpace(string(f.line))) + utf8.RuneCountInString(first[:attr])
+}
+
+func (f *formatter) push(tok token, candidate, preserve bool, sel selectionState) {
+	start := -1
+	if candidate && f.opts.Dedup != DedupOff {
+		start = len(f.pending)
+		f.open++
+	}
+	ns := f.scope().declare(tok.raw)
+	f.stack = append(f.stack, element{name: tok.name, start: start, line: tok.line, dups: f.removed, preserve: preserve, ns: ns, sel: sel})
+}
+
+// selecting reports whether the innermost open element is selected
+func (f *formatter) selecting() bool {
+	if f.selection == nil {
+		return true
+	}
+	if len(f.stack) == 0 {
+		return f.rootSel.selected()
+	}
+	return f.stack[len(f.stack)-1].sel.selected()
+}
+
+// scope returns the namespaces in scope inside the innermost open element
+func (f *formatter) scope() namespaceScope {
+	if len(f.stack) == 0 {
+		return nil
+	}
+	return f.stack[len(f.stack)-1].ns
+}
+
+// preserves reports whether whitespace is significant inside the element
+// started by tok. xml:space applies to the element and everything in it,
+// until an inner element sets it back to "default".
+func (f *formatter) preserves(tok token) bool {
+	if strings.Contains(tok.raw, "xml:space") {
+		for _, a := range scanAttributes(tok.raw) {
+			if a.name != "xml:space" || a.eq < 0 {
+				continue
+			}
+			switch a.value(tok.raw) {
+			case "preserve":
+				return true
+			case "default":
+				return false
+			}
+		}
+	}
+	return f.preserve[tok.name] || f.preserving()
+}
+
+// preserving reports whether the innermost open element preserves whitespace
+func (f *formatter) preserving() bool {
+	return len(f.stack) > 0 && f.stack[len(f.stack)-1].preserve
+}
+
+func (f *formatter) pop() {
+	top := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	if top.start < 0 {
+		return
+	}
+	f.open--
+	// An element closed on the line it started on is handled as a single line
+	if top.start < len(f.pending) {
+		f.closed, f.closedLine, f.closedDups = top.start, top.line, top.dups
+	}
+}
+
+// keepOpen stops the open elements from being removed as duplicates, as
+// they contain content deduplication must leave alone
+func (f *formatter) keepOpen() {
+	for i := range f.stack {
+		if f.stack[i].start >= 0 {
+			f.stack[i].start = -1
+			f.open--
+		}
+	}
+}
+
+// siblings returns the hashes of the elements kept so far in the current scope
+func (f *formatter) siblings() map[uint64]int {
+	if f.opts.Dedup == DedupGlobal || len(f.stack) == 0 {
+		return f.rootSeen
+	}
+	parent := &f.stack[len(f.stack)-1]
+	if parent.seen == nil {
+		parent.seen = make(map[uint64]int)
+	}
+	return parent.seen
+}
+
+// endLine writes the current line. inside reports that the line break falls
+// inside a token, in which case trailing whitespace belongs to that token.
+func (f *formatter) endLine(inside bool) {
+	f.formatLine(inside)
+
+	// A multi-line element that ended on this line is dropped as a whole if
+	// an identical sibling was kept before
+	if f.closed >= 0 {
+		text := string(f.pending[f.closed:])
+		if f.isDuplicate(computeSemanticHash(text, f.scope()), f.closedLine, strings.TrimSpace(text)) {
+			// Duplicates removed inside the element are gone with it
+			f.removed = f.closedDups + 1
+			if f.opts.ReportDuplicates {
+				removed := f.duplicates[len(f.duplicates)-1]
+				f.duplicates = append(f.duplicates[:f.closedDups], removed)
+			}
+			f.pending = f.pending[:f.closed]
+		}
+	}
+
+	if f.open == 0 {
+		f.flush()
+	}
+	f.resetLine()
+}
+
+func (f *formatter) formatLine(inside bool) {
+	line := string(f.line)
+	if f.preserved || f.untouched {
+		f.writeLine(0, 0, line)
+		return
+	}
+	if !inside && !f.hasPreserved {
+		line = trimRightSpace(line)
+	}
+
+	// CDATA content is character data and comments are often laid out by
+	// hand: continuation lines are kept verbatim, including their
+	// indentation and blank lines
+	if f.continued && (f.contKind == TokenCData || f.contKind == TokenComment) {
+		f.writeLine(0, 0, line)
+		return
+	}
+
+	line = trimLeftSpace(line)
+	if line == "" {
+		return
+	}
+
+	indent, align := f.contIndent, f.contAlign
+	if !f.continued {
+		indent, align = f.minDepth, 0
+	}
+
+	// Only complete, balanced lines of elements are deduplicated; removing an
+	// unbalanced line or one carrying text would change the structure
+	if f.opts.Dedup != DedupOff && !f.continued && !inside && (f.hasElement || f.hasNode) &&
+		!f.hasText && !f.hasExempt && !f.hasKept && !f.hasPreserved && !f.hasUntouched && f.minDepth == f.startDepth && f.depth == f.startDepth {
+		if f.isDuplicate(computeSemanticHash(line, f.scope()), f.lineNo, line) {
+			return
+		}
+	}
+
+	f.writeLine(indent, align, line)
+}
+
+// isDuplicate records the element at line in the current scope and reports
+// whether an identical sibling was kept before
+func (f *formatter) isDuplicate(semanticHash uint64, line int, text string) bool {
+	seen := f.siblings()
+	if first, ok := seen[semanticHash]; ok {
+		f.removed++
+		if f.opts.ReportDuplicates {
+			f.duplicates = append(f.duplicates, Duplicate{Line: line, Text: text, DuplicateOf: first})
+		}
+		return true
+	}
+	seen[semanticHash] = line
+	return false
+}
+
+func (f *formatter) resetLine() {
+	f.line = f.line[:0]
+	f.lineNo++
+	f.startDepth, f.minDepth = f.depth, f.depth
+	f.continued, f.hasContent, f.hasElement, f.hasText, f.hasExempt = false, false, false, false, false
+	f.hasKept, f.hasNode = false, false
+	f.preserved = f.preserving()
+	f.hasPreserved = f.preserved
+	f.untouched = !f.selecting()
+	f.hasUntouched = f.untouched
+	f.closed = -1
+}
+
+func (f *formatter) writeLine(indent, align int, line string) {
+	// Apply consistent indentation using cached strings with optimized writes
+	if indent < len(f.indentCache) {
+		f.pending = append(f.pending, f.indentCache[indent]...)
+	} else {
+		f.pending = append(f.pending, strings.Repeat(indentUnit(f.opts), indent)...) // Fallback for deep nesting
+	}
+	for i := 0; i < align; i++ {
+		f.pending = append(f.pending, ' ')
+	}
+	f.pending = append(f.pending, line...)
+	f.pending = append(f.pending, '\n')
+
+	// Elements too large to hold back are written out and kept
+	if len(f.pending) > MAX_SUBTREE_BYTES {
+		for i := range f.stack {
+			f.stack[i].start = -1
+		}
+		f.open = 0
+		f.closed = -1
+		f.flush()
+	}
+}
+
+// indentUnit returns the indentation of one nesting level
+func indentUnit(opts Options) string {
+	if opts.IndentTabs {
+		return "\t"
+	}
+	width := opts.IndentWidth
+	if width <= 0 {
+		width = DEFAULT_INDENT_WIDTH
+	}
+	return strings.Repeat(" ", width)
+}
+
+// flush writes the lines held in pending
+func (f *formatter) flush() {
+	if len(f.pending) == 0 {
+		return
+	}
+	if _, err := f.output.Write(f.pending); err != nil && f.err == nil {
+		f.err = err
+	}
+	f.pending = f.pending[:0]
+}
+
+// trimLeftSpace removes leading whitespace using WHITESPACE_THRESHOLD
+func trimLeftSpace(s string) string {
+	start := 0
+	for start < len(s) && s[start] <= WHITESPACE_THRESHOLD {
+		start++
+	}
+	return s[start:]
+}
+
+// trimRightSpace removes trailing whitespace using WHITESPACE_THRESHOLD
+func trimRightSpace(s string) string {
+	end := len(s)
+	for end > 0 && s[end-1] <= WHITESPACE_THRESHOLD {
+		end--
+	}
+	return s[:end]
+}
+
+// fastTrimSpace provides optimized whitespace trimming for XML processing
+// Key optimizations:
+// - Fast path for strings that don't need trimming (common case)
+// - Direct byte comparisons using WHITESPACE_THRESHOLD constant
+// - Single allocation when trimming is needed
+// Performance: O(1) for pre-trimmed strings, O(n) worst case
+func fastTrimSpace(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+
+	// Fast path: check endpoints first to avoid scanning (most strings are pre-trimmed)
+	if s[0] > WHITESPACE_THRESHOLD && s[len(s)-1] > WHITESPACE_THRESHOLD {
+		return s // No allocation needed
+	}
+
+	// Find first non-whitespace
+	start := 0
+	for start < len(s) && s[start] <= WHITESPACE_THRESHOLD {
+		start++
+	}
+
+	if start == len(s) {
+		return ""
+	}
+
+	// Find last non-whitespace
+	end := len(s) - 1
+	for end >= start && s[end] <= WHITESPACE_THRESHOLD {
+		end--
+	}
+
+	if start == 0 && end == len(s)-1 {
+		return s
+	}
+
+	return s[start : end+1]
+}
@@ -0,0 +1,275 @@
+package fixml
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// TokenKind classifies the markup constructs recognized by the tokenizer
+type TokenKind int
+
+const (
+	TokenText     TokenKind = iota // Character data between markup, including whitespace
+	TokenStartTag                  // <name ...>
+	TokenEndTag                    // </name>
+	TokenEmptyTag                  // <name .../>
+	TokenComment                   // <!-- ... -->
+	TokenCData                     // <![CDATA[ ... ]]>
+	TokenProcInst                  // <?target ... ?>, including the XML declaration
+	TokenDoctype                   // <!DOCTYPE ...> and other <! declarations
+)
+
+// token is one markup construct or run of text. raw holds the exact input
+// bytes, so concatenating the raw text of all tokens reproduces the input.
+type token struct {
+	kind TokenKind
+	raw  string
+	name string // Element name for tags, target for processing instructions
+	line int    // 1-based position of the first byte; col counts runes
+	col  int
+
+	// unterminated is the kind of markup a text token started as when the
+	// input ended (or a '<' interrupted a tag) before the markup was closed;
+	// TokenText for every other token
+	unterminated TokenKind
+}
+
+// tokenizer splits a cleaned XML stream into tokens without building a tree.
+// It is deliberately lenient: a '<' that cannot start markup is text, and
+// markup left unterminated at end of input is returned as text, so any input
+// can be formatted without losing bytes.
+type tokenizer struct {
+	r    *bufio.Reader
+	buf  []byte
+	line int
+	col  int
+}
+
+func newTokenizer(r *bufio.Reader) *tokenizer {
+	return &tokenizer{r: r, line: 1, col: 1}
+}
+
+// next returns the next token, or io.EOF once the input is exhausted
+func (t *tokenizer) next() (token, error) {
+	t.buf = t.buf[:0]
+	tok := token{line: t.line, col: t.col}
+
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return tok, err
+	}
+	t.consume(b)
+
+	if b != '<' {
+		tok.kind = TokenText
+		return t.finish(tok, t.readText())
+	}
+
+	next, err := t.r.Peek(1)
+	if err != nil {
+		tok.kind = TokenText
+		return t.finish(tok, t.readText())
+	}
+
+	switch c := next[0]; {
+	case c == '/':
+		tok.kind = TokenEndTag
+		return t.finish(tok, t.readTag(&tok))
+	case c == '?':
+		tok.kind = TokenProcInst
+		return t.finish(tok, t.readUntil("?>"))
+	case c == '!':
+		if t.hasPrefix("!--") {
+			tok.kind = TokenComment
+			t.skip(3)
+			return t.finish(tok, t.readUntil("-->"))
+		}
+		if t.hasPrefix("![CDATA[") {
+			tok.kind = TokenCData
+			t.skip(8)
+			return t.finish(tok, t.readUntil("]]>"))
+		}
+		tok.kind = TokenDoctype
+		return t.finish(tok, t.readDeclaration())
+	case isNameStart(c):
+		tok.kind = TokenStartTag
+		return t.finish(tok, t.readTag(&tok))
+	default:
+		// A '<' that cannot start markup is kept as text
+		tok.kind = TokenText
+		return t.finish(tok, t.readText())
+	}
+}
+
+// finish fills in raw and name. Markup that did not terminate becomes text.
+func (t *tokenizer) finish(tok token, complete bool) (token, error) {
+	tok.raw = string(t.buf)
+	if !complete {
+		tok.kind, tok.unterminated = TokenText, tok.kind
+		return tok, nil
+	}
+
+	switch tok.kind {
+	case TokenStartTag, TokenEmptyTag:
+		tok.name = scanName(tok.raw[1:])
+	case TokenEndTag:
+		tok.name = scanName(tok.raw[2:])
+	case TokenProcInst:
+		tok.name = scanName(tok.raw[2:])
+	}
+	return tok, nil
+}
+
+// consume appends b to the current token and advances the position
+func (t *tokenizer) consume(b byte) {
+	t.buf = append(t.buf, b)
+	if b == '\n' {
+		t.line++
+		t.col = 1
+	} else if b&0xC0 != 0x80 { // Count runes, not UTF-8 continuation bytes
+		t.col++
+	}
+}
+
+// consumeSlice is consume for a run of bytes
+func (t *tokenizer) consumeSlice(p []byte) {
+	t.buf = append(t.buf, p...)
+	if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+		t.line += bytes.Count(p[:i+1], []byte{'\n'})
+		t.col = 1
+		p = p[i+1:]
+	}
+	for _, b := range p {
+		if b&0xC0 != 0x80 {
+			t.col++
+		}
+	}
+}
+
+func (t *tokenizer) hasPrefix(s string) bool {
+	p, _ := t.r.Peek(len(s))
+	return string(p) == s
+}
+
+func (t *tokenizer) skip(n int) {
+	for i := 0; i < n; i++ {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return
+		}
+		t.consume(b)
+	}
+}
+
+// readText consumes character data up to, not including, the next '<'
+func (t *tokenizer) readText() bool {
+	for {
+		chunk, err := t.r.ReadSlice('<')
+		if err == nil {
+			t.r.UnreadByte()
+			t.consumeSlice(chunk[:len(chunk)-1])
+			return true
+		}
+		t.consumeSlice(chunk)
+		if err != bufio.ErrBufferFull {
+			return true // End of input ends the text
+		}
+	}
+}
+
+// readUntil consumes input through the terminator; false if input ends first
+func (t *tokenizer) readUntil(terminator string) bool {
+	last := terminator[len(terminator)-1]
+	for {
+		chunk, err := t.r.ReadSlice(last)
+		t.consumeSlice(chunk)
+		if err == nil && bytes.HasSuffix(t.buf, []byte(terminator)) {
+			return true
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return false
+		}
+	}
+}
+
+// readTag consumes a start, empty or end tag through its closing '>'.
+// Quoted attribute values may contain '>', but a '<' anywhere means the tag
+// is malformed: it is cut off there and returned as text.
+func (t *tokenizer) readTag(tok *token) bool {
+	var quote byte
+	for {
+		// Scan what is buffered, then read more
+		if _, err := t.r.Peek(1); err != nil {
+			return false
+		}
+		chunk, _ := t.r.Peek(t.r.Buffered())
+		for i, b := range chunk {
+			switch {
+			case b == '<':
+				t.consumeSlice(chunk[:i])
+				t.r.Discard(i)
+				return false
+			case quote != 0:
+				if b == quote {
+					quote = 0
+				}
+			case b == '"' || b == '\'':
+				quote = b
+			case b == '>':
+				t.consumeSlice(chunk[:i+1])
+				t.r.Discard(i + 1)
+				if tok.kind == TokenStartTag && t.buf[len(t.buf)-2] == '/' {
+					tok.kind = TokenEmptyTag
+				}
+				return true
+			}
+		}
+		t.consumeSlice(chunk)
+		t.r.Discard(len(chunk))
+	}
+}
+
+// readDeclaration consumes <!DOCTYPE ...> and similar declarations,
+// including an internal subset in square brackets
+func (t *tokenizer) readDeclaration() bool {
+	var quote byte
+	brackets := 0
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return false
+		}
+		t.consume(b)
+
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '[':
+			brackets++
+		case b == ']':
+			brackets--
+		case b == '>' && brackets <= 0:
+			return true
+		}
+	}
+}
+
+// isNameStart reports whether c can start an XML name. Bytes >= 0x80 are
+// accepted so that non-ASCII names are recognized without decoding.
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || c >= 0x80
+}
+
+// scanName returns the name at the start of s, ending at whitespace, '/', '>' or '?'
+func scanName(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= WHITESPACE_THRESHOLD || c == '/' || c == '>' || c == '?' {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,249 @@
+package fixml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const MAX_SYNTAX_ERRORS = 100 // Well-formedness errors recorded per document
+
+// SyntaxError is a well-formedness error in the input. Line and Col are
+// 1-based; Col counts characters. Positions refer to the input after the
+// byte order mark is removed and line endings are normalized, which leaves
+// line numbers unchanged.
+type SyntaxError struct {
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Message)
+}
+
+// validator checks the token stream for well-formedness: matching start and
+// end tags, unique attribute names, legal element names and escaped '&'.
+// It only reads tokens, so it runs alongside formatting without buffering.
+type validator struct {
+	open   []token // Start tags not yet closed
+	errors []SyntaxError
+}
+
+func (v *validator) errorAt(line, col int, format string, a ...interface{}) {
+	if len(v.errors) < MAX_SYNTAX_ERRORS {
+		v.errors = append(v.errors, SyntaxError{Line: line, Col: col, Message: fmt.Sprintf(format, a...)})
+	}
+}
+
+func (v *validator) token(tok token) {
+	switch tok.kind {
+	case TokenStartTag, TokenEmptyTag:
+		v.checkName(tok, tok.name, 1)
+		v.checkAttributes(tok)
+		if tok.kind == TokenStartTag {
+			v.open = append(v.open, tok)
+		}
+	case TokenEndTag:
+		v.checkName(tok, tok.name, 2)
+		v.closeElement(tok)
+	case TokenText:
+		v.checkText(tok)
+	}
+}
+
+// closeElement matches an end tag against the innermost open element.
+// Like the formatter, a mismatched end tag still closes one element.
+func (v *validator) closeElement(tok token) {
+	if len(v.open) == 0 {
+		v.errorAt(tok.line, tok.col, "unexpected end tag </%s> with no open element", tok.name)
+		return
+	}
+	top := v.open[len(v.open)-1]
+	v.open = v.open[:len(v.open)-1]
+	if top.name != tok.name {
+		v.errorAt(tok.line, tok.col, "mismatched end tag </%s>, expected </%s> (opened at %d:%d)",
+			tok.name, top.name, top.line, top.col)
+	}
+}
+
+// finish reports the elements left open at the end of the input and
+// returns all errors in input order
+func (v *validator) finish() []SyntaxError {
+	for _, tok := range v.open {
+		v.errorAt(tok.line, tok.col, "unclosed element <%s>", tok.name)
+	}
+	v.open = nil
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		a, b := v.errors[i], v.errors[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return v.errors
+}
+
+// checkName reports a name that is not a legal XML name; offset is the
+// length of the markup before the name ("<" or "</")
+func (v *validator) checkName(tok token, name string, offset int) {
+	if !isXMLName(name) {
+		v.errorAt(tok.line, tok.col+offset, "illegal element name %q", name)
+	}
+}
+
+// checkAttributes reports attribute names that occur twice in one tag
+func (v *validator) checkAttributes(tok token) {
+	s := tok.raw
+	i := skipName(s, 1)
+	var seen []string // Tags have few attributes; a slice beats a map
+	for {
+		i = skipSpace(s, i)
+		if i >= len(s) || s[i] == '>' || s[i] == '/' {
+			return
+		}
+		nameStart := i
+		i = skipName(s, i)
+		if i == nameStart {
+			i++ // Not a name; skip the character
+			continue
+		}
+		name := s[nameStart:i]
+		for _, prev := range seen {
+			if prev == name {
+				line, col := advance(tok.line, tok.col, s[:nameStart])
+				v.errorAt(line, col, "duplicate attribute %q on <%s>", name, tok.name)
+				break
+			}
+		}
+		seen = append(seen, name)
+
+		i = skipSpace(s, i)
+		if i >= len(s) || s[i] != '=' {
+			continue
+		}
+		i = skipSpace(s, i+1)
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return
+			}
+			v.checkReferences(tok, s, i+1, i+1+end)
+			i += end + 2
+		} else {
+			for i < len(s) && s[i] > WHITESPACE_THRESHOLD && s[i] != '>' && s[i] != '/' {
+				i++
+			}
+		}
+	}
+}
+
+// checkText reports unescaped '<' and '&' and markup cut off by the end of input
+func (v *validator) checkText(tok token) {
+	if tok.unterminated != TokenText {
+		v.errorAt(tok.line, tok.col, "unterminated %s", kindName(tok.unterminated))
+		return
+	}
+
+	s := tok.raw
+	if strings.HasPrefix(s, "<") {
+		if len(s) > 1 && s[1] > WHITESPACE_THRESHOLD {
+			v.errorAt(tok.line, tok.col+1, "illegal element name %q", scanName(s[1:]))
+		} else {
+			v.errorAt(tok.line, tok.col, "unescaped '<' in text")
+		}
+	}
+	v.checkReferences(tok, s, 0, len(s))
+}
+
+// checkReferences reports every '&' in s[start:end] that does not begin a
+// character or entity reference
+func (v *validator) checkReferences(tok token, s string, start, end int) {
+	for i := start; i < end; i++ {
+		if s[i] != '&' {
+			continue
+		}
+		semi := strings.IndexByte(s[i:end], ';')
+		if semi > 0 && isReference(s[i+1:i+semi]) {
+			i += semi
+			continue
+		}
+		line, col := advance(tok.line, tok.col, s[:i])
+		v.errorAt(line, col, "unescaped '&' (use &amp;)")
+	}
+}
+
+// isReference reports whether ref (without '&' and ';') is a well-formed
+// character reference or entity name
+func isReference(ref string) bool {
+	if strings.HasPrefix(ref, "#x") {
+		return len(ref) > 2 && strings.Trim(ref[2:], "0123456789abcdefABCDEF") == ""
+	}
+	if strings.HasPrefix(ref, "#") {
+		return len(ref) > 1 && strings.Trim(ref[1:], "0123456789") == ""
+	}
+	return isXMLName(ref)
+}
+
+// advance returns the position after s when s starts at line:col
+func advance(line, col int, s string) (int, int) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			line++
+			col = 1
+		} else if s[i]&0xC0 != 0x80 {
+			col++
+		}
+	}
+	return line, col
+}
+
+func kindName(kind TokenKind) string {
+	switch kind {
+	case TokenStartTag, TokenEmptyTag:
+		return "start tag"
+	case TokenEndTag:
+		return "end tag"
+	case TokenComment:
+		return "comment"
+	case TokenCData:
+		return "CDATA section"
+	case TokenProcInst:
+		return "processing instruction"
+	case TokenDoctype:
+		return "declaration"
+	}
+	return "markup"
+}
+
+// isXMLName reports whether s matches the Name production of XML 1.0
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == utf8.RuneError {
+			return false
+		}
+		if !isNameStartRune(r) && (i == 0 || !isNameRune(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameStartRune(r rune) bool {
+	switch {
+	case r == ':' || r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z':
+		return true
+	case r < 0xC0:
+		return false
+	}
+	return r <= 0xD6 || r >= 0xD8 && r <= 0xF6 || r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D || r >= 0x37F && r <= 0x1FFF || r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F || r >= 0x2C00 && r <= 0x2FEF || r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF || r >= 0xFDF0 && r <= 0xFFFD || r >= 0x10000 && r <= 0xEFFFF
+}
+
+func isNameRune(r rune) bool {
+	return r == '-' || r == '.' || r >= '0' && r <= '9' || r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F || r >= 0x203F && r <= 0x2040
+}
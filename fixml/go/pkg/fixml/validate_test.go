@@ -0,0 +1,91 @@
+package fixml
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []SyntaxError
+	}{
+		{"well-formed", "<a x=\"1\" y=\"2\">\n  <b>t &amp; &#38; &#x26; &lt;</b>\n  <c/>\n</a>\n", nil},
+		{"unclosed element", "<r>\n<ok>\n", []SyntaxError{
+			{1, 1, "unclosed element <r>"},
+			{2, 1, "unclosed element <ok>"},
+		}},
+		{"mismatched end tag", "<a>\n  <b>\n</a>\n", []SyntaxError{
+			{1, 1, "unclosed element <a>"},
+			{3, 1, "mismatched end tag </a>, expected </b> (opened at 2:3)"},
+		}},
+		{"end tag with no open element", "<a>\n</b>\n</a>\n</a>\n", []SyntaxError{
+			{2, 1, "mismatched end tag </b>, expected </a> (opened at 1:1)"},
+			{3, 1, "unexpected end tag </a> with no open element"},
+			{4, 1, "unexpected end tag </a> with no open element"},
+		}},
+		{"duplicate attribute", "<a x=\"1\" y=\"2\" x=\"3\"/>\n", []SyntaxError{
+			{1, 16, "duplicate attribute \"x\" on <a>"},
+		}},
+		{"illegal element names", "<r>\n<1a/>\n<.c/>\n</r>\n", []SyntaxError{
+			{2, 2, "illegal element name \"1a\""},
+			{3, 2, "illegal element name \".c\""},
+		}},
+		{"stray ampersand", "<r>fish & chips &amp; &#38;</r>\n", []SyntaxError{
+			{1, 9, "unescaped '&' (use &amp;)"},
+		}},
+		{"unterminated comment", "<r>\n<!-- open\n", []SyntaxError{
+			{1, 1, "unclosed element <r>"},
+			{2, 1, "unterminated comment"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			res, err := Process(strings.NewReader(tt.input), &out, Options{})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if !reflect.DeepEqual(res.Errors, tt.want) {
+				t.Errorf("Errors = %+v, want %+v", res.Errors, tt.want)
+			}
+			if out.Len() == 0 {
+				t.Errorf("no output without Strict")
+			}
+		})
+	}
+}
+
+// The element names of the spec compliance fixture are all legal
+func TestValidateElementNameRules(t *testing.T) {
+	content, err := os.ReadFile("../../../tests/xml-spec-compliance/element-name-rules.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	res, err := Process(bytes.NewReader(content), &out, Options{Strict: true})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(res.Errors) > 0 {
+		t.Errorf("Errors = %+v, want none", res.Errors)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	var out bytes.Buffer
+	res, err := Process(strings.NewReader("<a>\n<b>\n</a>\n"), &out, Options{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "input is not well-formed (2 errors); no output written") {
+		t.Errorf("Process error = %v, want the input refused", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("Strict wrote %q", out.String())
+	}
+	if len(res.Errors) != 2 {
+		t.Errorf("Errors = %+v, want 2", res.Errors)
+	}
+}
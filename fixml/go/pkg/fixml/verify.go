@@ -0,0 +1,418 @@
+package fixml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// XML_NAMESPACE is the namespace bound to the xml prefix
+const XML_NAMESPACE = "http://www.w3.org/XML/1998/namespace"
+
+// Kinds of the nodes compared by verify
+const (
+	verifyDocument = iota
+	verifyElement
+	verifyComment
+	verifyProcInst
+	verifyDirective
+)
+
+// verifyNode is a node of the tree encoding/xml reads from a document.
+// Character data is not a node of its own: the text directly inside an
+// element is collected in text with whitespace runs collapsed, so changes
+// to indentation and line breaks never count as differences.
+type verifyNode struct {
+	kind      int
+	name      string // Element name or processing instruction target
+	attrs     string // Attributes sorted by name, values with whitespace collapsed
+	text      string // Character data, comment or directive content, collapsed
+	children  []*verifyNode
+	line      int    // Line the node starts on
+	key       string // The node without its text and children
+	canonical int    // ID of the whole subtree, for finding removed duplicates
+	preserve  bool   // Whitespace inside the element is significant
+}
+
+// finish describes the node once its content is complete
+func (n *verifyNode) finish(ids subtreeIDs) {
+	n.key = fmt.Sprintf("%d\x00%s\x00%s", n.kind, n.name, n.attrs)
+	children := make([]int, len(n.children))
+	for i, child := range n.children {
+		children[i] = child.canonical
+	}
+	n.canonical = ids.id(n.key, n.text, children)
+}
+
+// subtreeIDs numbers subtrees so that equal subtrees, and only those, get
+// the same ID. A subtree is looked up by its root and the IDs of its
+// children, so comparing two costs nothing and the table grows with the
+// document, not with its depth.
+type subtreeIDs map[string]int
+
+// id returns the ID of the subtree with the given root key and text and
+// children. The text cannot contain \x01 or \x02, which XML does not allow.
+func (ids subtreeIDs) id(key, text string, children []int) int {
+	b := make([]byte, 0, len(key)+len(text)+2+len(children)*binary.MaxVarintLen32)
+	b = append(b, key...)
+	b = append(b, '\x02')
+	b = append(b, text...)
+	b = append(b, '\x01')
+	for _, child := range children {
+		b = binary.AppendUvarint(b, uint64(child))
+	}
+	id, ok := ids[string(b)]
+	if !ok {
+		id = len(ids)
+		ids[string(b)] = id
+	}
+	return id
+}
+
+// label names the node in messages
+func (n *verifyNode) label() string {
+	switch n.kind {
+	case verifyElement:
+		return "<" + n.name + ">"
+	case verifyComment:
+		return "comment"
+	case verifyProcInst:
+		return "<?" + n.name + "?>"
+	case verifyDirective:
+		return "<!" + strings.SplitN(n.text, " ", 2)[0] + ">"
+	}
+	return "document"
+}
+
+// verifier compares the input and output trees of one run
+type verifier struct {
+	opts    Options
+	exempt  map[string]bool     // Options.DedupExempt
+	profile map[int]bool        // Lines of the PackageReference items ProfileMSBuild may remove
+	ids     subtreeIDs          // Shared by both trees
+	output  map[int]bool        // Every output subtree, for DedupGlobal
+	reduced map[*verifyNode]int // Input nodes as deduplication leaves them
+}
+
+// verify checks that the output of a run says the same as its input and
+// that formatting it again changes nothing. Both documents are read with
+// encoding/xml, in non-strict mode so input fixml repairs (such as unquoted
+// attributes) can still be compared. The trees must be equal except for
+// whitespace in text (outside elements that preserve it) and attribute
+// values, the XML declaration, attribute
+// order, and the nodes the run removed: duplicates of a node the output
+// kept, and PackageReference items resolved by ProfileMSBuild. With
+// Organize, siblings may also appear in a different order.
+func verify(input, output []byte, opts Options, res *Result) error {
+	preserve := make(map[string]bool, len(opts.PreserveSpace))
+	for _, name := range opts.PreserveSpace {
+		preserve[name] = true
+	}
+	ids := make(subtreeIDs)
+	in, err := parseVerifyTree(input, preserve, ids)
+	if err != nil {
+		return fmt.Errorf("could not parse input: %v", err)
+	}
+	out, err := parseVerifyTree(output, preserve, ids)
+	if err != nil {
+		return fmt.Errorf("could not parse output: %v", err)
+	}
+
+	v := verifier{
+		opts:    opts,
+		exempt:  make(map[string]bool, len(opts.DedupExempt)),
+		ids:     ids,
+		output:  make(map[int]bool),
+		reduced: make(map[*verifyNode]int),
+	}
+	for _, name := range opts.DedupExempt {
+		v.exempt[name] = true
+	}
+	if opts.Profile == ProfileMSBuild {
+		v.profile = make(map[int]bool, len(res.Duplicates))
+		for _, d := range res.Duplicates {
+			v.profile[d.Line] = true
+		}
+	}
+	if opts.Dedup == DedupGlobal {
+		var collect func(n *verifyNode)
+		collect = func(n *verifyNode) {
+			v.output[n.canonical] = true
+			for _, child := range n.children {
+				collect(child)
+			}
+		}
+		collect(out)
+	}
+	if err := v.match(in, out); err != nil {
+		return err
+	}
+
+	// The output has to be a fixed point of the same run
+	opts.Verify = false
+	opts.Canonical = CanonicalNone
+	var again bytes.Buffer
+	if _, err := Process(bytes.NewReader(output), &again, opts); err != nil {
+		return fmt.Errorf("could not format the output again: %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), output) {
+		line := 1 + bytes.Count(output[:commonPrefix(again.Bytes(), output)], []byte("\n"))
+		return fmt.Errorf("output is not stable: formatting it again changes line %d", line)
+	}
+	return nil
+}
+
+// match compares an input node with the output node it became. Input
+// children missing from the output must have been removed by the run.
+func (v *verifier) match(in, out *verifyNode) error {
+	if in.key != out.key || !v.sameText(in, out) {
+		return fmt.Errorf("line %d: %s differs in the output (line %d)", in.line, in.label(), out.line)
+	}
+
+	// Input children wait in a queue per key, in document order, so an
+	// output child only tries the inputs it can match
+	queues := make(map[string][]int)
+	for i, child := range in.children {
+		queues[child.key] = append(queues[child.key], i)
+	}
+	used := make([]bool, len(in.children))
+	next := 0
+	for _, o := range out.children {
+		queue := queues[o.key]
+		if !v.opts.Organize {
+			// Without Organize the order is kept: inputs before the last match were removed
+			for len(queue) > 0 && queue[0] < next {
+				queue = queue[1:]
+			}
+		}
+		// The first difference inside a candidate explains a mismatch best
+		var firstErr error
+		found := -1
+		for q, i := range queue {
+			if err := v.match(in.children[i], o); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			found = q
+			break
+		}
+		if found < 0 {
+			if firstErr != nil {
+				return firstErr
+			}
+			return fmt.Errorf("output line %d: %s does not appear in the input", o.line, o.label())
+		}
+
+		i := queue[found]
+		used[i], next = true, i+1
+		if found == 0 {
+			queues[o.key] = queue[1:]
+		} else {
+			queues[o.key] = append(queue[:found], queue[found+1:]...)
+		}
+	}
+
+	siblings := make(map[int]bool, len(out.children))
+	for _, o := range out.children {
+		siblings[o.canonical] = true
+	}
+	for i, child := range in.children {
+		if used[i] {
+			continue
+		}
+		kept := siblings
+		if v.opts.Dedup == DedupGlobal {
+			kept = v.output
+		}
+		switch {
+		case child.kind == verifyElement && v.profile[child.line] && localName(child.name) == "PackageReference":
+		case v.opts.Dedup == DedupOff || child.kind == verifyElement && v.exempt[localName(child.name)] || v.keeps(child),
+			!kept[child.canonical] && !kept[v.reduce(child)]:
+			return fmt.Errorf("line %d: %s is missing from the output", child.line, child.label())
+		}
+	}
+	return nil
+}
+
+// sameText compares the text of an input node with its output node. CDATA
+// sections deduplicated by policy take their text with them, so the output
+// text then only has to be a subsequence of the input words.
+func (v *verifier) sameText(in, out *verifyNode) bool {
+	if in.kind != verifyElement || v.opts.CData == NodeKeep {
+		return in.text == out.text
+	}
+	words := strings.Fields(in.text)
+	for _, word := range strings.Fields(out.text) {
+		for len(words) > 0 && words[0] != word {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			return false
+		}
+		words = words[1:]
+	}
+	return true
+}
+
+// keeps reports whether a node is, or contains, a comment or processing
+// instruction its NodePolicy never removes
+func (v *verifier) keeps(n *verifyNode) bool {
+	switch {
+	case n.kind == verifyComment && v.opts.Comments == NodeKeep,
+		n.kind == verifyProcInst && v.opts.ProcInsts == NodeKeep:
+		return true
+	}
+	for _, child := range n.children {
+		if v.keeps(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// reduce returns the subtree ID of an input node after deduplication
+// removed its descendants; a removed element can repeat a kept one only
+// once its own duplicates are gone
+func (v *verifier) reduce(n *verifyNode) int {
+	if r, ok := v.reduced[n]; ok {
+		return r
+	}
+	var children []int
+	seen := make(map[int]bool)
+	for _, child := range n.children {
+		r := v.reduce(child)
+		switch {
+		case v.opts.Dedup == DedupGlobal && (v.output[child.canonical] || v.output[r]):
+			continue
+		case v.opts.Dedup == DedupSiblings && seen[r]:
+			continue
+		}
+		seen[r] = true
+		children = append(children, r)
+	}
+	v.reduced[n] = v.ids.id(n.key, n.text, children)
+	return v.reduced[n]
+}
+
+// parseVerifyTree reads a document, in any encoding fixml reads, into a
+// tree. Text inside elements with xml:space="preserve" or named in
+// preserve is kept exactly.
+func parseVerifyTree(content []byte, preserve map[string]bool, ids subtreeIDs) (*verifyNode, error) {
+	d := xml.NewDecoder(NewCleanReader(bytes.NewReader(content)))
+	d.Strict = false
+	// The clean reader has already converted the document to UTF-8
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+
+	root := &verifyNode{kind: verifyDocument, line: 1}
+	stack := []*verifyNode{root}
+	texts := []*strings.Builder{{}}
+	for {
+		line, _ := d.InputPos()
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		var n *verifyNode
+		switch t := t.(type) {
+		case xml.StartElement:
+			n = &verifyNode{kind: verifyElement, name: verifyName(t.Name), attrs: verifyAttributes(t.Attr), line: line}
+			n.preserve = preserve[t.Name.Local] || parent.preserve
+			for _, a := range t.Attr {
+				if a.Name.Local == "space" && (a.Name.Space == XML_NAMESPACE || a.Name.Space == "xml") {
+					n.preserve = a.Value == "preserve" || a.Value != "default" && n.preserve
+				}
+			}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+			texts = append(texts, &strings.Builder{})
+			continue
+		case xml.EndElement:
+			if len(stack) > 1 {
+				parent.text = texts[len(texts)-1].String()
+				if !parent.preserve {
+					parent.text = collapseSpace(parent.text)
+				}
+				parent.finish(ids)
+				stack, texts = stack[:len(stack)-1], texts[:len(texts)-1]
+			}
+			continue
+		case xml.CharData:
+			texts[len(texts)-1].Write(t)
+			continue
+		case xml.Comment:
+			n = &verifyNode{kind: verifyComment, text: collapseSpace(string(t))}
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				continue // The declaration is rewritten on purpose
+			}
+			n = &verifyNode{kind: verifyProcInst, name: t.Target, text: collapseSpace(string(t.Inst))}
+		case xml.Directive:
+			n = &verifyNode{kind: verifyDirective, text: collapseSpace(string(t))}
+		default:
+			continue
+		}
+		n.line = line
+		n.finish(ids)
+		parent.children = append(parent.children, n)
+	}
+	if len(stack) > 1 {
+		return nil, fmt.Errorf("unclosed element %s", stack[len(stack)-1].label())
+	}
+	// Text outside the root element is only whitespace in a well-formed document
+	root.finish(ids)
+	return root, nil
+}
+
+func verifyName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+// localName strips the namespace from a name made by verifyName
+func localName(name string) string {
+	if i := strings.LastIndexByte(name, '}'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// verifyAttributes describes the attributes of an element. Namespace
+// declarations are left out: names are compared expanded, and deduplication
+// and hoisting may change where namespaces are declared.
+func verifyAttributes(attrs []xml.Attr) string {
+	var parts []string
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+			continue
+		}
+		parts = append(parts, verifyName(a.Name)+"="+collapseSpace(a.Value))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x00")
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// commonPrefix returns the number of leading bytes a and b share
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/n-ae/portfolio/fixml/go/pkg/fixml"
+)
+
+// REPORT_JSON is the --report format that replaces the human-readable messages
+const REPORT_JSON = "json"
+
+// report is the document written by --report json
+type report struct {
+	Files   []fileReport  `json:"files"`
+	Summary summaryReport `json:"summary"`
+}
+
+// fileReport describes the outcome of one input file
+type fileReport struct {
+	File              string              `json:"file"`
+	Output            string              `json:"output,omitempty"` // Empty for --check, --diff and stdout
+	Changed           bool                `json:"changed"`
+	Encoding          fixml.Encoding      `json:"encoding,omitempty"` // Detected input encoding
+	DuplicatesRemoved int                 `json:"duplicates_removed"`
+	Warnings          []fixml.Warning     `json:"warnings"`       // At most fixml.MAX_WARNINGS_PER_RULE per rule
+	WarningCounts     map[string]int      `json:"warning_counts"` // Findings per rule, including those not listed
+	Fixes             []string            `json:"fixes"`
+	Duplicates        []fixml.Duplicate   `json:"duplicates"`
+	Errors            []fixml.SyntaxError `json:"errors"` // Well-formedness errors
+	DurationMs        float64             `json:"duration_ms"`
+	Error             string              `json:"error,omitempty"`
+}
+
+type summaryReport struct {
+	Files             int     `json:"files"`
+	Changed           int     `json:"changed"`
+	Unchanged         int     `json:"unchanged"`
+	Failed            int     `json:"failed"`
+	Invalid           int     `json:"invalid"` // Files with well-formedness errors
+	DuplicatesRemoved int     `json:"duplicates_removed"`
+	DurationMs        float64 `json:"duration_ms"`
+}
+
+// newFileReport converts the outcome of one file; slices are never null so
+// consumers can iterate without checks
+func newFileReport(file string, res fixml.Result, err error, elapsed time.Duration) fileReport {
+	r := fileReport{
+		File:              file,
+		Output:            res.OutputFile,
+		Changed:           res.Changed,
+		Encoding:          res.Encoding,
+		DuplicatesRemoved: res.DuplicatesRemoved,
+		Warnings:          res.Warnings,
+		WarningCounts:     res.RuleCounts,
+		Fixes:             res.Fixes,
+		Duplicates:        res.Duplicates,
+		Errors:            res.Errors,
+		DurationMs:        milliseconds(elapsed),
+	}
+	if r.Warnings == nil {
+		r.Warnings = []fixml.Warning{}
+	}
+	if r.WarningCounts == nil {
+		r.WarningCounts = map[string]int{}
+	}
+	if r.Fixes == nil {
+		r.Fixes = []string{}
+	}
+	if r.Duplicates == nil {
+		r.Duplicates = []fixml.Duplicate{}
+	}
+	if r.Errors == nil {
+		r.Errors = []fixml.SyntaxError{}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// writeReport writes the JSON report of a run together with its totals
+func writeReport(w io.Writer, files []fileReport, elapsed time.Duration) error {
+	doc := report{Files: files, Summary: summaryReport{Files: len(files), DurationMs: milliseconds(elapsed)}}
+	for _, f := range files {
+		if len(f.Errors) > 0 {
+			doc.Summary.Invalid++
+		}
+		switch {
+		case f.Error != "":
+			doc.Summary.Failed++
+		case f.Changed:
+			doc.Summary.Changed++
+			doc.Summary.DuplicatesRemoved += f.DuplicatesRemoved
+		default:
+			doc.Summary.Unchanged++
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false) // Keep XML text readable
+	return enc.Encode(doc)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
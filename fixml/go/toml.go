@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML used by .fixml.toml files: tables,
+// arrays of tables, dotted keys, basic and literal strings, integers,
+// booleans, arrays and inline tables. Multi-line strings, floats and dates
+// are not supported. The result has the shape encoding/json produces, so
+// it can be decoded into the same structures as a JSON configuration.
+func parseTOML(content string) (map[string]any, error) {
+	p := &tomlParser{s: content}
+	root := map[string]any{}
+	current := root
+	for {
+		p.skipBlank()
+		if p.i >= len(p.s) {
+			return root, nil
+		}
+
+		if p.s[p.i] == '[' {
+			array := strings.HasPrefix(p.s[p.i:], "[[")
+			closing := "]"
+			p.i++
+			if array {
+				p.i++
+				closing = "]]"
+			}
+			keys, err := p.keys()
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(p.s[p.i:], closing) {
+				return nil, p.errorf("expected %q", closing)
+			}
+			p.i += len(closing)
+			if current, err = p.table(root, keys, array); err != nil {
+				return nil, err
+			}
+		} else {
+			keys, err := p.keys()
+			if err != nil {
+				return nil, err
+			}
+			if p.i >= len(p.s) || p.s[p.i] != '=' {
+				return nil, p.errorf("expected '=' after key")
+			}
+			p.i++
+			p.skipSpace()
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.set(current, keys, value); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	s string
+	i int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.s[:p.i], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// skipBlank skips whitespace, line breaks and comments
+func (p *tomlParser) skipBlank() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\r', '\n':
+			p.i++
+		case '#':
+			for p.i < len(p.s) && p.s[p.i] != '\n' {
+				p.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endLine accepts trailing spaces and a comment before the line break
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	if p.i < len(p.s) && p.s[p.i] == '#' {
+		for p.i < len(p.s) && p.s[p.i] != '\n' {
+			p.i++
+		}
+	}
+	if p.i < len(p.s) && p.s[p.i] != '\n' && p.s[p.i] != '\r' {
+		return p.errorf("unexpected %q", p.s[p.i])
+	}
+	return nil
+}
+
+// keys parses a dotted key such as a."b.c".d
+func (p *tomlParser) keys() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.i >= len(p.s) {
+			return nil, p.errorf("expected a key")
+		}
+
+		var key string
+		switch c := p.s[p.i]; {
+		case c == '"' || c == '\'':
+			var err error
+			if key, err = p.str(); err != nil {
+				return nil, err
+			}
+		default:
+			start := p.i
+			for p.i < len(p.s) && isBareKeyByte(p.s[p.i]) {
+				p.i++
+			}
+			if p.i == start {
+				return nil, p.errorf("expected a key")
+			}
+			key = p.s[start:p.i]
+		}
+		keys = append(keys, key)
+
+		p.skipSpace()
+		if p.i >= len(p.s) || p.s[p.i] != '.' {
+			return keys, nil
+		}
+		p.i++
+	}
+}
+
+func isBareKeyByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// table returns the table a [header] or [[header]] selects, creating it
+func (p *tomlParser) table(root map[string]any, keys []string, array bool) (map[string]any, error) {
+	parent, err := p.parent(root, keys)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+
+	if array {
+		list, ok := parent[last].([]any)
+		if !ok && parent[last] != nil {
+			return nil, p.errorf("key %q is already defined", last)
+		}
+		table := map[string]any{}
+		parent[last] = append(list, table)
+		return table, nil
+	}
+
+	switch existing := parent[last].(type) {
+	case nil:
+		table := map[string]any{}
+		parent[last] = table
+		return table, nil
+	case map[string]any:
+		return existing, nil
+	}
+	return nil, p.errorf("key %q is already defined", last)
+}
+
+// parent walks all keys but the last, creating intermediate tables; for an
+// array of tables the most recent entry is used
+func (p *tomlParser) parent(table map[string]any, keys []string) (map[string]any, error) {
+	for _, key := range keys[:len(keys)-1] {
+		switch next := table[key].(type) {
+		case nil:
+			created := map[string]any{}
+			table[key] = created
+			table = created
+		case map[string]any:
+			table = next
+		case []any:
+			last, ok := next[len(next)-1].(map[string]any)
+			if !ok {
+				return nil, p.errorf("key %q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, p.errorf("key %q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func (p *tomlParser) set(table map[string]any, keys []string, value any) error {
+	parent, err := p.parent(table, keys)
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return p.errorf("key %q is already defined", last)
+	}
+	parent[last] = value
+	return nil
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.i >= len(p.s) {
+		return nil, p.errorf("expected a value")
+	}
+
+	switch c := p.s[p.i]; {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.s[p.i:], "true"):
+		p.i += len("true")
+		return true, nil
+	case strings.HasPrefix(p.s[p.i:], "false"):
+		p.i += len("false")
+		return false, nil
+	case c == '+' || c == '-' || c >= '0' && c <= '9':
+		start := p.i
+		p.i++
+		for p.i < len(p.s) && (p.s[p.i] >= '0' && p.s[p.i] <= '9' || p.s[p.i] == '_') {
+			p.i++
+		}
+		n, err := strconv.ParseInt(strings.ReplaceAll(p.s[start:p.i], "_", ""), 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %q", p.s[start:p.i])
+		}
+		return n, nil
+	}
+	return nil, p.errorf("unsupported value")
+}
+
+// str parses a basic ("...") or literal ('...') single-line string
+func (p *tomlParser) str() (string, error) {
+	quote := p.s[p.i]
+	if strings.HasPrefix(p.s[p.i:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	p.i++
+
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c == quote:
+			p.i++
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			if p.i+1 >= len(p.s) {
+				return "", p.errorf("unterminated string")
+			}
+			p.i++
+			switch e := p.s[p.i]; e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'u', 'U':
+				size := 4
+				if e == 'U' {
+					size = 8
+				}
+				if p.i+size >= len(p.s) {
+					return "", p.errorf("invalid escape")
+				}
+				r, err := strconv.ParseUint(p.s[p.i+1:p.i+1+size], 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid escape")
+				}
+				b.WriteRune(rune(r))
+				p.i += size
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+			p.i++
+		default:
+			b.WriteByte(c)
+			p.i++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// array parses [a, b, ...], which may span lines and end with a comma
+func (p *tomlParser) array() ([]any, error) {
+	p.i++
+	list := []any{}
+	for {
+		p.skipBlank()
+		if p.i < len(p.s) && p.s[p.i] == ']' {
+			p.i++
+			return list, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+
+		p.skipBlank()
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+		} else if p.i >= len(p.s) || p.s[p.i] != ']' {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// inlineTable parses { key = value, ... } on a single line
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.i++
+	table := map[string]any{}
+	p.skipSpace()
+	if p.i < len(p.s) && p.s[p.i] == '}' {
+		p.i++
+		return table, nil
+	}
+	for {
+		keys, err := p.keys()
+		if err != nil {
+			return nil, err
+		}
+		if p.i >= len(p.s) || p.s[p.i] != '=' {
+			return nil, p.errorf("expected '=' after key")
+		}
+		p.i++
+		p.skipSpace()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.set(table, keys, value); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.i >= len(p.s) {
+			return nil, p.errorf("unterminated inline table")
+		}
+		switch p.s[p.i] {
+		case ',':
+			p.i++
+		case '}':
+			p.i++
+			return table, nil
+		default:
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]any
+	}{
+		{"empty", "", map[string]any{}},
+		{"comments and blank lines", "# settings\n\n  # indented\nindent_width = 4 # trailing\n", map[string]any{"indent_width": int64(4)}},
+		{"CRLF", "indent_tabs = true\r\ndedup = \"off\"\r\n", map[string]any{"indent_tabs": true, "dedup": "off"}},
+		{"no final newline", "indent_tabs = false", map[string]any{"indent_tabs": false}},
+		{"integers", "a = +1_000\nb = -2\nc = 0", map[string]any{"a": int64(1000), "b": int64(-2), "c": int64(0)}},
+
+		{"basic string escapes", `s = "a\"b\\c\td\u00e9\U0001F600"`, map[string]any{"s": "a\"b\\c\td\u00e9\U0001F600"}},
+		{"literal string", `s = 'C:\path\"raw"'`, map[string]any{"s": `C:\path\"raw"`}},
+		{"hash inside string", `s = "a # b" # comment`, map[string]any{"s": "a # b"}},
+		{"quoted keys", "\"a.b\" = 1\n'c d' = 2", map[string]any{"a.b": int64(1), "c d": int64(2)}},
+		{"dotted keys", "a.b.c = 1\na . d = 2", map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1)}, "d": int64(2)}}},
+
+		{"array", `only = ["//ItemGroup", '//Target']`, map[string]any{"only": []any{"//ItemGroup", "//Target"}}},
+		{"empty array", "skip = []", map[string]any{"skip": []any{}}},
+		{"multi-line array", "skip = [\n  \"a\", # first\n\n  \"b\",\n]", map[string]any{"skip": []any{"a", "b"}}},
+		{"nested array", "a = [[1, 2], []]", map[string]any{"a": []any{[]any{int64(1), int64(2)}, []any{}}}},
+
+		{"table", "[warnings]\nempty-element = true\n", map[string]any{"warnings": map[string]any{"empty-element": true}}},
+		{"dotted table", "[a.b]\nc = 1\n[a]\nd = 2", map[string]any{"a": map[string]any{"b": map[string]any{"c": int64(1)}, "d": int64(2)}}},
+		{"inline table", "w = { a = true, b.c = 'x' }\ne = {}", map[string]any{
+			"w": map[string]any{"a": true, "b": map[string]any{"c": "x"}},
+			"e": map[string]any{},
+		}},
+		{"array of tables", "indent_width = 2\n[[overrides]]\nfiles = [\"*.csproj\"]\nindent_width = 4\n\n[[overrides]]\nfiles = [\"*.props\"]\n[overrides.warnings]\nmixed-quotes = false",
+			map[string]any{
+				"indent_width": int64(2),
+				"overrides": []any{
+					map[string]any{"files": []any{"*.csproj"}, "indent_width": int64(4)},
+					map[string]any{"files": []any{"*.props"}, "warnings": map[string]any{"mixed-quotes": false}},
+				},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.content)
+			if err != nil {
+				t.Fatalf("parseTOML(%q) failed: %v", tt.content, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML(%q) = %#v, want %#v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing equals", "indent_width 4", "line 1: expected '=' after key"},
+		{"missing value", "a =", "line 1: expected a value"},
+		{"missing key", "= 1", "line 1: expected a key"},
+		{"unsupported value", "a = 1.5", "line 1: unexpected '.'"},
+		{"bare word", "a = yes", "line 1: unsupported value"},
+		{"trailing garbage", "a = true false", "line 1: unexpected 'f'"},
+		{"invalid integer", "a = +", "line 1: invalid integer \"+\""},
+		{"duplicate key", "a = 1\na = 2", "line 2: key \"a\" is already defined"},
+		{"key over table", "[a]\n[b]\na = 1\n[a.x]\n[b.a.y]", "line 5: key \"a\" is not a table"},
+		{"table over value", "a = 1\n[a]", "line 2: key \"a\" is already defined"},
+		{"array of tables over table", "[a]\n[[a]]", "line 2: key \"a\" is already defined"},
+		{"unclosed header", "[a\nb = 1", "line 1: expected \"]\""},
+		{"unclosed array header", "[[a]\n", "line 1: expected \"]]\""},
+
+		{"unterminated string", "a = \"abc\nb = 1", "line 1: unterminated string"},
+		{"unterminated at end", "a = 'abc", "line 1: unterminated string"},
+		{"multi-line string", "a = \"\"\"x\"\"\"", "line 1: multi-line strings are not supported"},
+		{"invalid escape", `a = "\q"`, `line 1: invalid escape \q`},
+		{"short unicode escape", `a = "\u00"`, "line 1: invalid escape"},
+
+		{"unclosed array", "a = [1, 2", "line 1: expected ',' or ']' in array"},
+		{"array without comma", "a = [1 2]", "line 1: expected ',' or ']' in array"},
+		{"unclosed inline table", "a = { b = 1", "line 1: unterminated inline table"},
+		{"inline table without comma", "a = { b = 1 c = 2 }", "line 1: expected ',' or '}' in inline table"},
+		{"duplicate inline key", "a = { b = 1, b = 2 }", "line 1: key \"b\" is already defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.content)
+			if err == nil {
+				t.Fatalf("parseTOML(%q) = %#v, want error %q", tt.content, got, tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("parseTOML(%q) error = %q, want %q", tt.content, err, tt.want)
+			}
+		})
+	}
+}
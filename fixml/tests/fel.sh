@@ -1,11 +1,23 @@
 #!/bin/bash
 
 # Modified fel.sh to work with fixml output
-# Usage: ./fel.sh original_file processed_file
+# Usage: ./fel.sh [--ordered|--exact] original_file processed_file
+#
+# Lines are compared as sets by default. --ordered compares them in order,
+# for output whose line order is under test, such as --organize (.o) output.
+# --exact compares the files byte for byte, for fixtures whose repeated
+# lines or indentation are under test.
+
+compare=set
+case "$1" in
+    --ordered) compare=ordered; shift ;;
+    --exact) compare=exact; shift ;;
+esac
 
 if [ $# -ne 2 ]; then
-    echo "Usage: $0 <original_file> <processed_file>"
+    echo "Usage: $0 [--ordered|--exact] <original_file> <processed_file>"
     echo "Example: $0 sample.csproj sample.csproj.organized"
+    echo "Example: $0 --ordered sample.o.expected.csproj sample.csproj.organized"
     exit 1
 fi
 
@@ -22,7 +34,18 @@ if [ ! -f "$processed_file" ]; then
     exit 1
 fi
 
+normalize() {
+    sed 's/^\xEF\xBB\xBF//; s/^[[:space:]]*//; s/[[:space:]]*$//' "$1"
+}
+
+if [ "$compare" = exact ]; then
+    cmp -s "$original_file" "$processed_file" || diff "$original_file" "$processed_file"
+    exit 0
+fi
+
 # Compare files, ignoring whitespace differences and BOM characters
-comm -3 \
-    <(sed 's/^\xEF\xBB\xBF//; s/^[[:space:]]*//; s/[[:space:]]*$//' "$original_file" | sort -u) \
-    <(sed 's/^\xEF\xBB\xBF//; s/^[[:space:]]*//; s/[[:space:]]*$//' "$processed_file" | sort -u)
+if [ "$compare" = ordered ]; then
+    diff <(normalize "$original_file") <(normalize "$processed_file") | grep '^[<>]'
+    exit 0
+fi
+comm -3 <(normalize "$original_file" | sort -u) <(normalize "$processed_file" | sort -u)